@@ -0,0 +1,281 @@
+// docker-credential-kpxc is a docker credential helper keeping the registry
+// credentials in a dedicated KeePassXC group. It uses the association of
+// the kpxc command, run "kpxc associate" once before. Enable it with
+//
+//	{"credsStore": "kpxc"}
+//
+// in ~/.docker/config.json.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	kpxc "gitea.olznet.de/OlzNet/golang-keepassxc-browser"
+)
+
+// GroupEnv overrides DefaultGroup, docker starts helpers without arguments.
+const GroupEnv string = "KPXC_DOCKER_GROUP"
+const DefaultGroup string = "Docker"
+
+// The browser protocol cannot list the entries of a group, so list reads
+// an index entry mapping the server URLs to their user names.
+const IndexUrl string = "https://docker-credential-kpxc.invalid"
+const IndexLogin string = "docker-credential-kpxc"
+
+// errNotFound is the message docker expects for missing credentials.
+var errNotFound = errors.New("credentials not found in native keychain")
+
+type credentials struct {
+	ServerURL string
+	Username  string
+	Secret    kpxc.Secret
+}
+
+type helper struct {
+	client *kpxc.Client
+	group  *kpxc.CredentialGroup
+	out    io.Writer
+}
+
+// entryUrl gives registries without scheme, e.g. ghcr.io, one to match by.
+func entryUrl(serverUrl string) string {
+	if strings.Contains(serverUrl, "://") {
+		return serverUrl
+	}
+	return "https://" + serverUrl
+}
+
+// openGroup looks the group up, creating it on the first store.
+func (h *helper) openGroup(create bool) (err error) {
+	found, err := h.group.Open(create)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errNotFound
+	}
+
+	return nil
+}
+
+// entries returns the entries of the group stored for url. KeePassXC also
+// returns entries of parent domains and does not tell their URL, it names
+// the entries it creates after the host, so only entries named like the
+// host of url are used.
+func (h *helper) entries(url string) (ret []kpxc.LoginEntry, err error) {
+	entries, err := h.group.Logins(url)
+	if err != nil {
+		return nil, err
+	}
+
+	host := kpxc.UrlHost(url)
+	for i := range entries {
+		if host != "" && strings.EqualFold(entries[i].Name, host) {
+			ret = append(ret, entries[i])
+			continue
+		}
+		entries[i].Wipe()
+	}
+
+	return ret, nil
+}
+
+func (h *helper) readIndex() (ret map[string]string, uuid string, err error) {
+	ret = make(map[string]string)
+	entries, err := h.entries(IndexUrl)
+	if err != nil {
+		return nil, "", err
+	}
+	defer kpxc.WipeEntries(entries)
+
+	for _, e := range entries {
+		if e.Login != IndexLogin {
+			continue
+		}
+		if err = json.Unmarshal(e.Password, &ret); err != nil {
+			return nil, "", fmt.Errorf("Invalid index entry %s: %v", e.Uuid, err)
+		}
+		return ret, e.Uuid, nil
+	}
+
+	return ret, "", nil
+}
+
+func (h *helper) updateIndex(serverUrl, username string) (err error) {
+	index, uuid, err := h.readIndex()
+	if err != nil {
+		return err
+	}
+	if username == "" {
+		delete(index, serverUrl)
+	} else {
+		index[serverUrl] = username
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	return h.group.SetLogin(IndexUrl, IndexLogin, kpxc.Secret(data), uuid)
+}
+
+func (h *helper) get(serverUrl string) (err error) {
+	if err = h.openGroup(false); err != nil {
+		return err
+	}
+	entries, err := h.entries(entryUrl(serverUrl))
+	if err != nil {
+		return err
+	}
+	defer kpxc.WipeEntries(entries)
+	if len(entries) == 0 {
+		return errNotFound
+	}
+
+	return json.NewEncoder(h.out).Encode(&credentials{
+		ServerURL: serverUrl,
+		Username:  entries[0].Login,
+		Secret:    entries[0].Password,
+	})
+}
+
+func (h *helper) store(c *credentials) (err error) {
+	if err = h.openGroup(true); err != nil {
+		return err
+	}
+	entries, err := h.entries(entryUrl(c.ServerURL))
+	if err != nil {
+		return err
+	}
+	defer kpxc.WipeEntries(entries)
+
+	// one login per registry, like the docker config
+	uuid := ""
+	if len(entries) > 0 {
+		uuid = entries[0].Uuid
+	}
+	if err = h.group.SetLogin(entryUrl(c.ServerURL), c.Username, c.Secret, uuid); err != nil {
+		return err
+	}
+
+	return h.updateIndex(c.ServerURL, c.Username)
+}
+
+func (h *helper) erase(serverUrl string) (err error) {
+	if err = h.openGroup(false); err != nil {
+		return err
+	}
+	entries, err := h.entries(entryUrl(serverUrl))
+	if err != nil {
+		return err
+	}
+	defer kpxc.WipeEntries(entries)
+
+	for _, e := range entries {
+		if _, err = h.client.DeleteEntry(e.Uuid); err != nil {
+			var perr *kpxc.ProtocolError
+			if errors.As(err, &perr) && perr.Code == kpxc.ErrCodeIncorrectAction {
+				return fmt.Errorf("Deleting entries is not supported by this KeePassXC")
+			}
+			return err
+		}
+	}
+
+	return h.updateIndex(serverUrl, "")
+}
+
+func (h *helper) list() (err error) {
+	index := make(map[string]string)
+	if err = h.openGroup(false); err == nil {
+		index, _, err = h.readIndex()
+	}
+	if err != nil && err != errNotFound {
+		return err
+	}
+
+	return json.NewEncoder(h.out).Encode(index)
+}
+
+func readServerUrl(r io.Reader) (ret string, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	if ret = strings.TrimSpace(string(data)); ret == "" {
+		return "", fmt.Errorf("No server URL given")
+	}
+
+	return ret, nil
+}
+
+func run(action string) (err error) {
+	var c credentials
+	var serverUrl string
+	switch action {
+	case "store":
+		if err = json.NewDecoder(os.Stdin).Decode(&c); err != nil {
+			return err
+		}
+		defer c.Secret.Wipe()
+		if c.ServerURL == "" {
+			return fmt.Errorf("No server URL given")
+		}
+	case "get", "erase":
+		if serverUrl, err = readServerUrl(os.Stdin); err != nil {
+			return err
+		}
+	case "list":
+	default:
+		return fmt.Errorf("Unknown action: %s", action)
+	}
+
+	assocFile, err := kpxc.DefaultAssocFile()
+	if err != nil {
+		return err
+	}
+	client, hash, err := kpxc.OpenClient("docker-credential-kpxc", assocFile)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if client.Associations[hash].Id == "" {
+		return fmt.Errorf("Not associated with the active database, run: kpxc associate")
+	}
+
+	group := DefaultGroup
+	if g := os.Getenv(GroupEnv); g != "" {
+		group = g
+	}
+	h := &helper{client: client, out: os.Stdout}
+	if h.group, err = kpxc.NewCredentialGroup(client, group); err != nil {
+		return err
+	}
+
+	switch action {
+	case "store":
+		return h.store(&c)
+	case "get":
+		return h.get(serverUrl)
+	case "erase":
+		return h.erase(serverUrl)
+	}
+
+	return h.list()
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s get|store|erase|list\n", os.Args[0])
+		os.Exit(2)
+	}
+	if err := run(os.Args[1]); err != nil {
+		// docker shows stdout, the not found message must be exact
+		fmt.Fprintln(os.Stdout, err)
+		os.Exit(1)
+	}
+}
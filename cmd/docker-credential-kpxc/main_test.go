@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	kpxc "gitea.olznet.de/OlzNet/golang-keepassxc-browser"
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+// testClient returns a client associated with a KdbxBackend on a new
+// database, served on a socket like KeePassXC.
+func testClient(t *testing.T) *kpxc.Client {
+	t.Helper()
+	dir := t.TempDir()
+	db := gokeepasslib.NewDatabase()
+	db.Credentials = gokeepasslib.NewPasswordCredentials("test")
+	root := gokeepasslib.NewGroup()
+	root.Name = "Root"
+	db.Content.Root.Groups = []gokeepasslib.Group{root}
+	db.LockProtectedEntries()
+	f, err := os.Create(filepath.Join(dir, "test.kdbx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gokeepasslib.NewEncoder(f).Encode(db)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend, err := kpxc.NewKdbxBackend(f.Name(), gokeepasslib.NewPasswordCredentials("test"),
+		kpxc.WithKdbxApprove(kpxc.KdbxAutoApprove))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "kpxc.sock")
+	srv, err := kpxc.NewSocketServer(path, kpxc.BrowserServerFactory(backend), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		srv.Serve(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	c, err := kpxc.NewClient("test", kpxc.WithAddress(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		if err = c.Connect(); err == nil {
+			break
+		}
+		if i == 50 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Cleanup(c.Close)
+	if _, err = c.ChangePublicKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Associate(); err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func newHelper(t *testing.T, c *kpxc.Client, out *bytes.Buffer) *helper {
+	t.Helper()
+	g, err := kpxc.NewCredentialGroup(c, "/"+DefaultGroup+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &helper{client: c, group: g, out: out}
+}
+
+func getLogin(t *testing.T, h *helper, serverUrl string) (ret string, err error) {
+	t.Helper()
+	h.out.(*bytes.Buffer).Reset()
+	if err = h.get(serverUrl); err != nil {
+		return "", err
+	}
+	var creds credentials
+	if err = json.Unmarshal(h.out.(*bytes.Buffer).Bytes(), &creds); err != nil {
+		t.Fatal(err)
+	}
+	if creds.ServerURL != serverUrl {
+		t.Errorf("got server URL %s, want %s", creds.ServerURL, serverUrl)
+	}
+
+	return creds.Username + ":" + string(creds.Secret), nil
+}
+
+func TestHelper(t *testing.T) {
+	c := testClient(t)
+	var out bytes.Buffer
+	h := newHelper(t, c, &out)
+
+	if _, err := getLogin(t, h, "ghcr.io"); err != errNotFound {
+		t.Errorf("get before store: %v, want not found", err)
+	}
+	if err := h.store(&credentials{ServerURL: "example.com", Username: "parent", Secret: kpxc.Secret("pw1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// entries of parent domains and of other groups are not used
+	other, err := c.CreateNewGroup("Other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.SetLogin("https://registry.example.com", "", "other", kpxc.Secret("x"), "", other.Uuid, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getLogin(t, h, "registry.example.com"); err != errNotFound {
+		t.Errorf("got %v, want not found", err)
+	}
+
+	if err = h.store(&credentials{ServerURL: "registry.example.com", Username: "bob", Secret: kpxc.Secret("pw2")}); err != nil {
+		t.Fatal(err)
+	}
+	// one login per registry
+	if err = h.store(&credentials{ServerURL: "registry.example.com", Username: "bob", Secret: kpxc.Secret("pw3")}); err != nil {
+		t.Fatal(err)
+	}
+	for url, want := range map[string]string{"example.com": "parent:pw1", "registry.example.com": "bob:pw3"} {
+		if got, err := getLogin(t, h, url); err != nil || got != want {
+			t.Errorf("get %s: got %s, %v, want %s", url, got, err, want)
+		}
+	}
+
+	out.Reset()
+	if err = h.list(); err != nil {
+		t.Fatal(err)
+	}
+	var index map[string]string
+	if err = json.Unmarshal(out.Bytes(), &index); err != nil {
+		t.Fatal(err)
+	}
+	if len(index) != 2 || index["example.com"] != "parent" || index["registry.example.com"] != "bob" {
+		t.Errorf("got index %v", index)
+	}
+}
@@ -0,0 +1,245 @@
+// git-credential-kpxc is a git credential helper keeping the credentials in
+// KeePassXC. It uses the association of the kpxc command, run
+// "kpxc associate" once before. Only the entries of its group are used,
+// changed or deleted. erase needs a KeePassXC answering delete-entry, with
+// others it leaves the entries alone. Enable it with
+//
+//	git config --global credential.helper 'kpxc -group Git'
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	kpxc "gitea.olznet.de/OlzNet/golang-keepassxc-browser"
+)
+
+const DefaultGroup string = "Git"
+
+// credential holds the attributes of the git credential protocol, see
+// gitcredentials(7).
+type credential struct {
+	Protocol string
+	Host     string
+	Path     string
+	Username string
+	Password kpxc.Secret
+}
+
+func readCredential(r io.Reader) (ret *credential, err error) {
+	ret = new(credential)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if line == "" {
+			break
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("Invalid line: %s", line)
+		}
+		switch k {
+		case "protocol":
+			ret.Protocol = v
+		case "host":
+			ret.Host = v
+		case "path":
+			ret.Path = v
+		case "username":
+			ret.Username = v
+		case "password":
+			ret.Password = kpxc.Secret(v)
+		case "url":
+			u, err := url.Parse(v)
+			if err != nil {
+				return nil, err
+			}
+			ret.Protocol, ret.Host, ret.Path = u.Scheme, u.Host, strings.TrimPrefix(u.Path, "/")
+			if u.User != nil {
+				ret.Username = u.User.Username()
+			}
+		}
+	}
+	if err = s.Err(); err != nil {
+		return nil, err
+	}
+	if ret.Protocol == "" || ret.Host == "" {
+		return nil, fmt.Errorf("Protocol and host required")
+	}
+
+	return ret, nil
+}
+
+// Url is what the entries are stored with, the path is only part of it if
+// git sends it (credential.useHttpPath).
+func (c *credential) Url() string {
+	u := c.Protocol + "://" + c.Host
+	if c.Path != "" {
+		u += "/" + c.Path
+	}
+
+	return u
+}
+
+type helper struct {
+	client *kpxc.Client
+	group  *kpxc.CredentialGroup
+	out    io.Writer
+}
+
+func (h *helper) get(c *credential) (err error) {
+	if found, err := h.group.Open(false); err != nil || !found {
+		return err
+	}
+	entries, err := h.group.Logins(c.Url())
+	if err != nil {
+		return err
+	}
+	defer kpxc.WipeEntries(entries)
+
+	// without a user name the best match of KeePassXC wins
+	for _, e := range entries {
+		if c.Username != "" && e.Login != c.Username {
+			continue
+		}
+		w := bufio.NewWriter(h.out)
+		fmt.Fprintf(w, "username=%s\n", e.Login)
+		w.WriteString("password=")
+		w.Write(e.Password)
+		w.WriteString("\n")
+		return w.Flush()
+	}
+
+	return nil
+}
+
+func (h *helper) store(c *credential) (err error) {
+	if c.Username == "" || len(c.Password) == 0 {
+		return nil
+	}
+	if _, err = h.group.Open(true); err != nil {
+		return err
+	}
+	entries, err := h.group.Logins(c.Url())
+	if err != nil {
+		return err
+	}
+	defer kpxc.WipeEntries(entries)
+
+	uuid := ""
+	for _, e := range entries {
+		if e.Login != c.Username {
+			continue
+		}
+		if e.Password.Equal(c.Password) {
+			return nil
+		}
+		uuid = e.Uuid
+		break
+	}
+	return h.group.SetLogin(c.Url(), c.Username, c.Password, uuid)
+}
+
+func (h *helper) erase(c *credential) (err error) {
+	if found, err := h.group.Open(false); err != nil || !found {
+		return err
+	}
+	entries, err := h.group.Logins(c.Url())
+	if err != nil {
+		return err
+	}
+	defer kpxc.WipeEntries(entries)
+
+	for _, e := range entries {
+		if c.Username != "" && e.Login != c.Username {
+			continue
+		}
+		// git passes the rejected password, keep entries changed meanwhile
+		if len(c.Password) > 0 && !e.Password.Equal(c.Password) {
+			continue
+		}
+		if _, err = h.client.DeleteEntry(e.Uuid); err != nil {
+			var perr *kpxc.ProtocolError
+			if errors.As(err, &perr) && perr.Code == kpxc.ErrCodeIncorrectAction {
+				// git carries on, a stale entry is only asked for again
+				fmt.Fprintf(os.Stderr, "git-credential-kpxc: KeePassXC cannot delete entries, keeping %s\n", e.Uuid)
+				return nil
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func run() (err error) {
+	group := flag.String("group", DefaultGroup, "keep the credentials in group `path`, entries elsewhere are left alone")
+	socket := flag.String("socket", "", "KeePassXC socket `path` (default: discovered)")
+	assocFile := flag.String("assoc", "", "association `file` (default: the one of kpxc)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] get|store|erase\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	action := flag.Arg(0)
+	switch action {
+	case "get", "store", "erase":
+	default:
+		// unknown actions are to be ignored
+		return nil
+	}
+
+	c, err := readCredential(os.Stdin)
+	if err != nil {
+		return err
+	}
+	defer c.Password.Wipe()
+
+	if *assocFile == "" {
+		if *assocFile, err = kpxc.DefaultAssocFile(); err != nil {
+			return err
+		}
+	}
+	var opts []kpxc.ClientOption
+	if *socket != "" {
+		opts = append(opts, kpxc.WithAddress(*socket))
+	}
+	client, hash, err := kpxc.OpenClient("git-credential-kpxc", *assocFile, opts...)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if client.Associations[hash].Id == "" {
+		return fmt.Errorf("Not associated with the active database, run: kpxc associate")
+	}
+
+	h := &helper{client: client, out: os.Stdout}
+	if h.group, err = kpxc.NewCredentialGroup(client, *group); err != nil {
+		return err
+	}
+	switch action {
+	case "get":
+		return h.get(c)
+	case "store":
+		return h.store(c)
+	}
+
+	return h.erase(c)
+}
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "git-credential-kpxc: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	kpxc "gitea.olznet.de/OlzNet/golang-keepassxc-browser"
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+// testClient returns a client associated with a KdbxBackend on a new
+// database, served on a socket like KeePassXC.
+func testClient(t *testing.T) *kpxc.Client {
+	t.Helper()
+	dir := t.TempDir()
+	db := gokeepasslib.NewDatabase()
+	db.Credentials = gokeepasslib.NewPasswordCredentials("test")
+	root := gokeepasslib.NewGroup()
+	root.Name = "Root"
+	db.Content.Root.Groups = []gokeepasslib.Group{root}
+	db.LockProtectedEntries()
+	f, err := os.Create(filepath.Join(dir, "test.kdbx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gokeepasslib.NewEncoder(f).Encode(db)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend, err := kpxc.NewKdbxBackend(f.Name(), gokeepasslib.NewPasswordCredentials("test"),
+		kpxc.WithKdbxApprove(kpxc.KdbxAutoApprove))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "kpxc.sock")
+	srv, err := kpxc.NewSocketServer(path, kpxc.BrowserServerFactory(backend), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		srv.Serve(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	c, err := kpxc.NewClient("test", kpxc.WithAddress(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		if err = c.Connect(); err == nil {
+			break
+		}
+		if i == 50 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Cleanup(c.Close)
+	if _, err = c.ChangePublicKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Associate(); err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func newHelper(t *testing.T, c *kpxc.Client, out *bytes.Buffer) *helper {
+	t.Helper()
+	g, err := kpxc.NewCredentialGroup(c, "/"+DefaultGroup+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &helper{client: c, group: g, out: out}
+}
+
+func TestReadCredential(t *testing.T) {
+	c, err := readCredential(strings.NewReader("url=https://bob@git.example.com/repo.git\npassword=pw\n\nignored=1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Url() != "https://git.example.com/repo.git" || c.Username != "bob" || string(c.Password) != "pw" {
+		t.Errorf("got %+v", c)
+	}
+	for _, in := range []string{"host=example.com\n", "protocol=https\n", "invalid\n"} {
+		if _, err = readCredential(strings.NewReader(in)); err == nil {
+			t.Errorf("%q accepted", in)
+		}
+	}
+}
+
+func TestHelper(t *testing.T) {
+	c := testClient(t)
+	var out bytes.Buffer
+	h := newHelper(t, c, &out)
+	cred := func(user, password string) *credential {
+		return &credential{Protocol: "https", Host: "git.example.com", Username: user, Password: kpxc.Secret(password)}
+	}
+	get := func(user string) string {
+		t.Helper()
+		out.Reset()
+		if err := h.get(cred(user, "")); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	if got := get(""); got != "" {
+		t.Errorf("get before store: %q", got)
+	}
+	// entries of other groups are left alone
+	other, err := c.CreateNewGroup("Other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.SetLogin("https://git.example.com", "", "eve", kpxc.Secret("x"), "", other.Uuid, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(""); got != "" {
+		t.Errorf("got %q from another group", got)
+	}
+
+	if err = h.store(cred("bob", "pw1")); err != nil {
+		t.Fatal(err)
+	}
+	if err = h.store(cred("bob", "pw2")); err != nil {
+		t.Fatal(err)
+	}
+	if err = h.store(cred("alice", "pw3")); err != nil {
+		t.Fatal(err)
+	}
+	for user, want := range map[string]string{
+		"bob":   "username=bob\npassword=pw2\n",
+		"alice": "username=alice\npassword=pw3\n",
+		"eve":   "",
+	} {
+		if got := get(user); got != want {
+			t.Errorf("get %s: got %q, want %q", user, got, want)
+		}
+	}
+
+	// KeePassXC without delete-entry keeps the entry
+	if err = h.erase(cred("bob", "pw2")); err != nil {
+		t.Errorf("erase without delete-entry: %v", err)
+	}
+	if got := get("bob"); got == "" {
+		t.Error("entry gone after erase without delete-entry")
+	}
+}
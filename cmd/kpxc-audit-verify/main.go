@@ -0,0 +1,65 @@
+// kpxc-audit-verify checks the hash chain of audit logs written by the
+// proxy and MITM. It finds records removed or altered within a log, not
+// ones cut off at its start or end, and without a key not edits by anybody
+// able to recompute the chain.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	kpxc "gitea.olznet.de/OlzNet/golang-keepassxc-browser"
+)
+
+func verify(file string, opts ...kpxc.AuditOption) (n int, err error) {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	return kpxc.VerifyAuditLog(r, opts...)
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s audit.log...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	keyFile := flag.String("key-file", "", "file holding the key the log was chained with")
+	flag.Parse()
+	files := flag.Args()
+
+	var opts []kpxc.AuditOption
+	if *keyFile != "" {
+		key, err := kpxc.ReadAuditKey(*keyFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		opts = append(opts, kpxc.WithAuditKey(key))
+	}
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	failed := false
+	for _, file := range files {
+		n, err := verify(file, opts...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: FAILED after %d records: %v\n", file, n, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s: OK, %d records\n", file, n)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
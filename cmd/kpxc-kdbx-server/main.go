@@ -0,0 +1,112 @@
+// kpxc-kdbx-server serves the KeePassXC browser protocol from a KDBX file,
+// for hosts without a running KeePassXC.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	kpxc "gitea.olznet.de/OlzNet/golang-keepassxc-browser"
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+const PasswordEnv string = "KPXC_KDBX_PASSWORD"
+
+func credentials(passwordFile, keyFile string) (ret *gokeepasslib.DBCredentials, err error) {
+	password, havePassword := os.LookupEnv(PasswordEnv)
+	if passwordFile != "" {
+		b, err := os.ReadFile(passwordFile)
+		if err != nil {
+			return nil, err
+		}
+		password = strings.TrimRight(string(b), "\r\n")
+		havePassword = true
+	}
+
+	switch {
+	case havePassword && keyFile != "":
+		return gokeepasslib.NewPasswordAndKeyCredentials(password, keyFile)
+	case keyFile != "":
+		return gokeepasslib.NewKeyCredentials(keyFile)
+	case havePassword:
+		return gokeepasslib.NewPasswordCredentials(password), nil
+	}
+
+	return nil, fmt.Errorf("Neither password (%s, -password-file) nor key file given", PasswordEnv)
+}
+
+func run() (err error) {
+	socket := flag.String("socket", "", "listen on `path` (default: KeePassXC socket location)")
+	stdio := flag.Bool("stdio", false, "serve one client as native messaging host on stdin/stdout")
+	passwordFile := flag.String("password-file", "", "read the database password from `file`")
+	keyFile := flag.String("keyfile", "", "unlock the database with key `file`")
+	autoApprove := flag.Bool("auto-approve", false, "approve every associate request")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] database.kdbx\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	creds, err := credentials(*passwordFile, *keyFile)
+	if err != nil {
+		return err
+	}
+
+	var opts []kpxc.KdbxOption
+	if *autoApprove {
+		opts = append(opts, kpxc.WithKdbxApprove(kpxc.KdbxAutoApprove))
+	}
+	backend, err := kpxc.NewKdbxBackend(flag.Arg(0), creds, opts...)
+	if err != nil {
+		return err
+	}
+
+	logger := log.New(os.Stderr, "kpxc-kdbx-server: ", log.LstdFlags)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *stdio {
+		bs, err := kpxc.NewBrowserServer(backend)
+		if err != nil {
+			return err
+		}
+		server, err := kpxc.NewServer(bs, &kpxc.StdinoutConnection{}, kpxc.WithServerLogger(logger))
+		if err != nil {
+			return err
+		}
+		return server.Serve(ctx)
+	}
+
+	if *socket == "" {
+		*socket = kpxc.SocketCandidates()[0]
+	}
+	if err = os.MkdirAll(filepath.Dir(*socket), 0700); err != nil {
+		return err
+	}
+
+	server, err := kpxc.NewSocketServer(*socket, kpxc.BrowserServerFactory(backend), logger)
+	if err != nil {
+		return err
+	}
+	logger.Printf("Serving %s on %s\n", flag.Arg(0), *socket)
+
+	return server.Serve(ctx)
+}
+
+func main() {
+	if err := run(); err != nil && err != context.Canceled {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,140 @@
+// kpxc-proxy is a drop-in replacement for keepassxc-proxy, the native
+// messaging host relaying between the browser extension and KeePassXC.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	kpxc "gitea.olznet.de/OlzNet/golang-keepassxc-browser"
+)
+
+// AuditEnv names the file the proxy appends its hash chained audit log to.
+const AuditEnv string = "KPXC_AUDIT_LOG"
+
+// AuditKeyEnv names a file holding the key the audit log is chained with,
+// kpxc-audit-verify needs it as well.
+const AuditKeyEnv string = "KPXC_AUDIT_KEY_FILE"
+
+// ExtensionsEnv holds further extension ids or origins to accept, comma
+// separated, e.g. of a development build.
+const ExtensionsEnv string = "KPXC_EXTENSION_IDS"
+
+func install(browser string) (err error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+
+	path, err := kpxc.InstallNativeManifest(browser, exe)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Installed manifest: %s\n", path)
+
+	return nil
+}
+
+func printManifest(browser string) (err error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	manifest, err := kpxc.NativeManifest(browser, exe)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", manifest)
+
+	return nil
+}
+
+func run() (err error) {
+	// browsers pass the manifest path and extension id as plain arguments,
+	// the flags are only used when started by hand
+	if len(os.Args) > 1 && strings.HasPrefix(os.Args[1], "-") &&
+		!strings.HasPrefix(os.Args[1], "--parent-window=") {
+		installFor := flag.String("install", "", "install the native messaging manifest for `browser`")
+		manifestFor := flag.String("manifest", "", "print the native messaging manifest for `browser`")
+		flag.Parse()
+
+		if *installFor != "" {
+			return install(*installFor)
+		}
+		if *manifestFor != "" {
+			return printManifest(*manifestFor)
+		}
+	}
+
+	// stdout carries the native messaging stream, log to stderr only
+	logger := log.New(os.Stderr, "kpxc-proxy: ", log.LstdFlags)
+
+	args := kpxc.ParseNativeArgs(os.Args[1:])
+	var extra []string
+	for _, id := range strings.Split(os.Getenv(ExtensionsEnv), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			extra = append(extra, id)
+		}
+	}
+	switch {
+	case args.ExtensionId == "":
+		logger.Printf("Started without extension id, not by a browser\n")
+	case !args.KnownExtension(extra...):
+		return fmt.Errorf("Unknown extension %s (manifest %s), allow it with %s",
+			args.ExtensionId, args.ManifestPath, ExtensionsEnv)
+	default:
+		logger.Printf("Started by %s (manifest %s)\n", args.ExtensionId, args.ManifestPath)
+	}
+
+	proxy, err := kpxc.NewKpXcProxy()
+	if err != nil {
+		return err
+	}
+	if file := os.Getenv(AuditEnv); file != "" {
+		var opts []kpxc.AuditOption
+		if keyFile := os.Getenv(AuditKeyEnv); keyFile != "" {
+			key, err := kpxc.ReadAuditKey(keyFile)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, kpxc.WithAuditKey(key))
+		}
+		audit, err := kpxc.OpenAuditLog(file, true, opts...)
+		if err != nil {
+			return err
+		}
+		defer audit.Close()
+		proxy.SetAuditLog(audit)
+	}
+
+	server, err := kpxc.NewServer(proxy, &kpxc.StdinoutConnection{}, kpxc.WithServerLogger(logger))
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err = server.Serve(ctx); err == context.Canceled {
+		return nil
+	}
+	return err
+}
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,495 @@
+// kpxc talks to the running KeePassXC over the browser protocol, so shell
+// scripts can fetch credentials from it.
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	kpxc "gitea.olznet.de/OlzNet/golang-keepassxc-browser"
+)
+
+// DefaultClientId is the client id of a new identity, a stored one keeps
+// its own.
+const DefaultClientId string = "kpxc-cli"
+
+// Exit codes, scripts can tell why a lookup failed.
+const (
+	exitOk            int = 0
+	exitError         int = 1
+	exitUsage         int = 2
+	exitNotConnected  int = 3
+	exitNotAssociated int = 4
+	exitLocked        int = 5
+	exitDenied        int = 6
+	exitNotFound      int = 7
+)
+
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+// connError is returned if KeePassXC cannot be reached.
+type connError struct {
+	err error
+}
+
+func (e *connError) Error() string {
+	return fmt.Sprintf("Unable to connect KeePassXC: %v", e.err)
+}
+
+func (e *connError) Unwrap() error {
+	return e.err
+}
+
+var errNotAssociated = errors.New("Not associated with the active database, run: kpxc associate")
+
+func exitCode(err error) int {
+	var uerr *usageError
+	var cerr *connError
+	var perr *kpxc.ProtocolError
+	var xerr *exitStatus
+	switch {
+	case err == nil:
+		return exitOk
+	case errors.As(err, &xerr):
+		return xerr.code
+	case errors.As(err, &uerr):
+		return exitUsage
+	case errors.As(err, &cerr):
+		return exitNotConnected
+	case errors.Is(err, errNotAssociated):
+		return exitNotAssociated
+	case errors.As(err, &perr):
+		switch perr.Code {
+		case kpxc.ErrCodeTimeoutOrNotConnected:
+			return exitNotConnected
+		case kpxc.ErrCodeAssociationFailed, kpxc.ErrCodeEncryptionKeyUnrecognized,
+			kpxc.ErrCodeNoSavedDatabasesFound:
+			return exitNotAssociated
+		case kpxc.ErrCodeDatabaseNotOpened, kpxc.ErrCodeDatabaseHashNotReceived:
+			return exitLocked
+		case kpxc.ErrCodeActionCancelledOrDenied, kpxc.ErrCodeAccessToAllEntriesDenied:
+			return exitDenied
+		case kpxc.ErrCodeNoLoginsFound, kpxc.ErrCodeNoGroupsFound, kpxc.ErrCodeNoValidUuidProvided:
+			return exitNotFound
+		}
+	}
+
+	return exitError
+}
+
+// session is what every command gets, a connected client with the
+// association of the active database selected.
+type session struct {
+	client    *kpxc.Client
+	hash      string
+	assocFile string
+	flags     *flag.FlagSet
+}
+
+// close ends the session, commands may do so before they are done.
+func (s *session) close() {
+	if s.client != nil {
+		s.client.Close()
+		s.client = nil
+	}
+}
+
+type command struct {
+	usage string
+	help  string
+	// assoc is set for commands requiring an association
+	assoc bool
+	flags func(*flag.FlagSet)
+	run   func(s *session, args []string) (*result, error)
+}
+
+var commands = map[string]*command{
+	"associate": {"", "associate with the active database", false, nil, cmdAssociate},
+	"test":      {"", "test the association with the active database", true, nil, cmdTest},
+	"logins":    {"[-password] url", "list the entries matching url", true, flagsLogins, cmdLogins},
+	"password":  {"[-login name] url", "print the password of an entry matching url", true, flagsLogin, cmdPassword},
+	"totp":      {"[-login name] uuid|url", "print the current TOTP of an entry", true, flagsLogin, cmdTotp},
+	"generate":  {"", "generate a password", false, nil, cmdGenerate},
+	"set":       {"[-group name] [-uuid uuid] url login", "create or update an entry, the password is read from stdin", true, flagsSet, cmdSet},
+	"groups":    {"", "list the groups of the active database", true, nil, cmdGroups},
+	"mkgroup":   {"path", "create a group, nested groups are separated by /", true, nil, cmdMkgroup},
+	"lock":      {"", "lock the active database", true, nil, cmdLock},
+	"run":       {"[-env VAR=ref] [-file VAR=ref] -- command [arguments]", "run a command with secrets of kpxc:// references in its environment", true, flagsRun, cmdRun},
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [options] command [arguments]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd := commands[name]
+		fmt.Fprintf(out, "  %s %s\n    \t%s\n", name, cmd.usage, cmd.help)
+	}
+	fmt.Fprintf(out, "\nOptions:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(out, "\nExit codes: 0 ok, 1 error, 2 usage, 3 KeePassXC not reachable, "+
+		"4 not associated, 5 database locked, 6 denied, 7 not found, run exits with the status of the command\n")
+}
+
+func saveAssoc(c *kpxc.Client, file string) (err error) {
+	if err = os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+
+	return c.SaveAssoc(file)
+}
+
+func cmdAssociate(s *session, args []string) (ret *result, err error) {
+	res, err := s.client.Associate()
+	if err != nil {
+		return nil, err
+	}
+	if err = saveAssoc(s.client, s.assocFile); err != nil {
+		return nil, err
+	}
+
+	return &result{
+		value:  map[string]string{"id": res.Id, "hash": res.Hash},
+		header: []string{"ID", "HASH"},
+		rows:   [][]string{{res.Id, res.Hash}},
+		plain:  []string{res.Id},
+	}, nil
+}
+
+func cmdTest(s *session, args []string) (ret *result, err error) {
+	res, err := s.client.TestAssociate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &result{
+		value:  map[string]string{"id": res.Id, "hash": res.Hash},
+		header: []string{"ID", "HASH"},
+		rows:   [][]string{{res.Id, res.Hash}},
+		plain:  []string{res.Id},
+	}, nil
+}
+
+func flagsLogins(fs *flag.FlagSet) {
+	fs.Bool("password", false, "include the passwords")
+}
+
+func flagsLogin(fs *flag.FlagSet) {
+	fs.String("login", "", "pick the entry with user `name` instead of the first one")
+}
+
+func flagsSet(fs *flag.FlagSet) {
+	fs.String("group", "", "create the entry in group `name`")
+	fs.String("group-uuid", "", "create the entry in the group with `uuid`")
+	fs.String("uuid", "", "update the entry with `uuid`")
+}
+
+func flagString(fs *flag.FlagSet, name string) string {
+	return fs.Lookup(name).Value.String()
+}
+
+func needArgs(args []string, n int) (err error) {
+	if len(args) != n {
+		return &usageError{fmt.Sprintf("Expected %d argument(s), got %d", n, len(args))}
+	}
+	return nil
+}
+
+func cmdLogins(s *session, args []string) (ret *result, err error) {
+	if err = needArgs(args, 1); err != nil {
+		return nil, err
+	}
+	withPassword := flagString(s.flags, "password") == "true"
+
+	res, err := s.client.GetLogins(args[0], "", "")
+	if err != nil {
+		return nil, err
+	}
+	ret = &result{header: []string{"NAME", "LOGIN", "UUID", "GROUP"}}
+	if withPassword {
+		ret.header = append(ret.header, "PASSWORD")
+	}
+	for i := range res.Entries {
+		e := &res.Entries[i]
+		row := []string{e.Name, e.Login, e.Uuid, e.Group}
+		line := e.Login
+		if withPassword {
+			row = append(row, string(e.Password))
+			line += "\t" + string(e.Password)
+		} else {
+			e.Password.Wipe()
+			e.Password = nil
+		}
+		ret.rows = append(ret.rows, row)
+		ret.plain = append(ret.plain, line)
+	}
+	ret.value = res.Entries
+
+	return ret, nil
+}
+
+// pickEntry returns the entry for url, the one with login if given.
+func pickEntry(s *session, url string) (ret *kpxc.LoginEntry, err error) {
+	login := flagString(s.flags, "login")
+	res, err := s.client.GetLogins(url, "", "")
+	if err != nil {
+		return nil, err
+	}
+	for i := range res.Entries {
+		if ret == nil && (login == "" || res.Entries[i].Login == login) {
+			ret = &res.Entries[i]
+			continue
+		}
+		res.Entries[i].Wipe()
+	}
+	if ret == nil {
+		return nil, kpxc.NewProtocolError(kpxc.ErrCodeNoLoginsFound, "No logins found")
+	}
+
+	return ret, nil
+}
+
+func cmdPassword(s *session, args []string) (ret *result, err error) {
+	if err = needArgs(args, 1); err != nil {
+		return nil, err
+	}
+	e, err := pickEntry(s, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return &result{
+		value:  map[string]interface{}{"uuid": e.Uuid, "login": e.Login, "password": e.Password},
+		header: []string{"LOGIN", "PASSWORD"},
+		rows:   [][]string{{e.Login, string(e.Password)}},
+		plain:  []string{string(e.Password)},
+	}, nil
+}
+
+// isUuid reports whether s is an entry uuid, 32 hex digits, anything else
+// is looked up as an URL, with or without a scheme.
+func isUuid(s string) bool {
+	if len(s) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+
+	return err == nil
+}
+
+func cmdTotp(s *session, args []string) (ret *result, err error) {
+	if err = needArgs(args, 1); err != nil {
+		return nil, err
+	}
+	uuid := args[0]
+	if !isUuid(uuid) {
+		e, err := pickEntry(s, uuid)
+		if err != nil {
+			return nil, err
+		}
+		e.Wipe()
+		uuid = e.Uuid
+	}
+
+	res, err := s.client.GetTotp(uuid)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Totp) == 0 {
+		return nil, kpxc.NewProtocolError(kpxc.ErrCodeNoValidUuidProvided, "No TOTP configured")
+	}
+
+	return &result{
+		value:  map[string]interface{}{"uuid": uuid, "totp": res.Totp},
+		header: []string{"UUID", "TOTP"},
+		rows:   [][]string{{uuid, string(res.Totp)}},
+		plain:  []string{string(res.Totp)},
+	}, nil
+}
+
+func cmdGenerate(s *session, args []string) (ret *result, err error) {
+	res, err := s.client.GeneratePassword(0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result{
+		value:  map[string]interface{}{"password": res.Password},
+		header: []string{"PASSWORD"},
+		rows:   [][]string{{string(res.Password)}},
+		plain:  []string{string(res.Password)},
+	}, nil
+}
+
+func readPassword() (ret kpxc.Secret, err error) {
+	r := bufio.NewReader(os.Stdin)
+	line, err := r.ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return nil, fmt.Errorf("No password on stdin: %v", err)
+	}
+	for len(line) > 0 && (line[len(line)-1] == '\n' || line[len(line)-1] == '\r') {
+		line = line[:len(line)-1]
+	}
+
+	return kpxc.Secret(line), nil
+}
+
+func cmdSet(s *session, args []string) (ret *result, err error) {
+	if err = needArgs(args, 2); err != nil {
+		return nil, err
+	}
+	password, err := readPassword()
+	if err != nil {
+		return nil, err
+	}
+	defer password.Wipe()
+
+	_, err = s.client.SetLogin(args[0], "", args[1], password,
+		flagString(s.flags, "group"), flagString(s.flags, "group-uuid"), flagString(s.flags, "uuid"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &result{
+		value:  map[string]string{"url": args[0], "login": args[1]},
+		header: []string{"URL", "LOGIN"},
+		rows:   [][]string{{args[0], args[1]}},
+	}, nil
+}
+
+func walkGroups(groups []kpxc.GroupChild, prefix string, fn func(path string, g *kpxc.GroupChild)) {
+	for i := range groups {
+		g := &groups[i]
+		path := prefix + g.Name
+		fn(path, g)
+		walkGroups(g.Children, path+"/", fn)
+	}
+}
+
+func cmdGroups(s *session, args []string) (ret *result, err error) {
+	res, err := s.client.GetDatabaseGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	ret = &result{value: res.Groups.Groups, header: []string{"PATH", "UUID"}}
+	walkGroups(res.Groups.Groups, "", func(path string, g *kpxc.GroupChild) {
+		ret.rows = append(ret.rows, []string{path, g.Uuid})
+		ret.plain = append(ret.plain, path)
+	})
+
+	return ret, nil
+}
+
+func cmdMkgroup(s *session, args []string) (ret *result, err error) {
+	if err = needArgs(args, 1); err != nil {
+		return nil, err
+	}
+	res, err := s.client.CreateNewGroup(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return &result{
+		value:  map[string]string{"name": res.Name, "uuid": res.Uuid},
+		header: []string{"NAME", "UUID"},
+		rows:   [][]string{{res.Name, res.Uuid}},
+		plain:  []string{res.Uuid},
+	}, nil
+}
+
+func cmdLock(s *session, args []string) (ret *result, err error) {
+	return &result{}, s.client.LockDatabase()
+}
+
+func run() (err error) {
+	format := flag.String("format", formatPlain, "output `format`: plain, table or json")
+	socket := flag.String("socket", "", "KeePassXC socket `path` (default: discovered)")
+	assocFile := flag.String("assoc", "", "association `file` (default: "+kpxc.AssocFileName+" in the user config directory)")
+	clientId := flag.String("client-id", DefaultClientId, "client `id` of a new identity")
+	timeout := flag.Int("timeout", 0, "`seconds` to wait for KeePassXC, e.g. for the user to approve")
+	flag.Usage = usage
+	flag.Parse()
+
+	if err = checkFormat(*format); err != nil {
+		return &usageError{err.Error()}
+	}
+	if flag.NArg() == 0 {
+		return &usageError{"No command given"}
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		return &usageError{fmt.Sprintf("Unknown command: %s", flag.Arg(0))}
+	}
+
+	fs := flag.NewFlagSet(flag.Arg(0), flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s %s\n", filepath.Base(os.Args[0]), flag.Arg(0), cmd.usage)
+		fs.PrintDefaults()
+	}
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	fs.Parse(flag.Args()[1:])
+
+	if *assocFile == "" {
+		if *assocFile, err = kpxc.DefaultAssocFile(); err != nil {
+			return err
+		}
+	}
+
+	opts := []kpxc.ClientOption{kpxc.WithTimeout(*timeout)}
+	if *socket != "" {
+		opts = append(opts, kpxc.WithAddress(*socket))
+	}
+	client, hash, err := kpxc.OpenClient(*clientId, *assocFile, opts...)
+	if err != nil {
+		var perr *kpxc.ProtocolError
+		if errors.As(err, &perr) {
+			return err
+		}
+		return &connError{err}
+	}
+	s := &session{client: client, hash: hash, assocFile: *assocFile, flags: fs}
+	defer s.close()
+
+	if cmd.assoc && client.Associations[hash].Id == "" {
+		return errNotAssociated
+	}
+
+	res, err := cmd.run(s, fs.Args())
+	if err != nil || res == nil {
+		return err
+	}
+
+	return (&output{format: *format, w: os.Stdout}).emit(res)
+}
+
+func main() {
+	err := run()
+	var xerr *exitStatus
+	if err != nil && !errors.As(err, &xerr) {
+		// protocol errors already read "Error: ..."
+		fmt.Fprintf(os.Stderr, "kpxc: %v\n", err)
+		if _, ok := err.(*usageError); ok {
+			flag.Usage()
+		}
+	}
+	os.Exit(exitCode(err))
+}
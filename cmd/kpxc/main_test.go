@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	kpxc "gitea.olznet.de/OlzNet/golang-keepassxc-browser"
+)
+
+func TestExitCode(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want int
+	}{
+		{nil, exitOk},
+		{errors.New("other"), exitError},
+		{&exitStatus{code: 42}, 42},
+		{&usageError{"usage"}, exitUsage},
+		{&connError{errors.New("no socket")}, exitNotConnected},
+		{errNotAssociated, exitNotAssociated},
+		{fmt.Errorf("wrapped: %w", errNotAssociated), exitNotAssociated},
+		{kpxc.NewProtocolError(kpxc.ErrCodeTimeoutOrNotConnected, ""), exitNotConnected},
+		{kpxc.NewProtocolError(kpxc.ErrCodeAssociationFailed, ""), exitNotAssociated},
+		{kpxc.NewProtocolError(kpxc.ErrCodeEncryptionKeyUnrecognized, ""), exitNotAssociated},
+		{kpxc.NewProtocolError(kpxc.ErrCodeNoSavedDatabasesFound, ""), exitNotAssociated},
+		{kpxc.NewProtocolError(kpxc.ErrCodeDatabaseNotOpened, ""), exitLocked},
+		{kpxc.NewProtocolError(kpxc.ErrCodeDatabaseHashNotReceived, ""), exitLocked},
+		{kpxc.NewProtocolError(kpxc.ErrCodeActionCancelledOrDenied, ""), exitDenied},
+		{kpxc.NewProtocolError(kpxc.ErrCodeAccessToAllEntriesDenied, ""), exitDenied},
+		{kpxc.NewProtocolError(kpxc.ErrCodeNoLoginsFound, ""), exitNotFound},
+		{kpxc.NewProtocolError(kpxc.ErrCodeNoGroupsFound, ""), exitNotFound},
+		{kpxc.NewProtocolError(kpxc.ErrCodeNoValidUuidProvided, ""), exitNotFound},
+		{fmt.Errorf("wrapped: %w", kpxc.NewProtocolError(kpxc.ErrCodeNoLoginsFound, "")), exitNotFound},
+		{kpxc.NewProtocolError(kpxc.ErrCodeCannotDecryptMessage, ""), exitError},
+	} {
+		if got := exitCode(tc.err); got != tc.want {
+			t.Errorf("%v: got %d, want %d", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestIsUuid(t *testing.T) {
+	for s, want := range map[string]bool{
+		"0123456789abcdef0123456789ABCDEF":         true,
+		"example.com":                              false,
+		"example.com/login":                        false,
+		"https://example.com":                      false,
+		"0123456789abcdef0123456789abcdeg":         false,
+		"0123456789abcdef0123456789abcdef.example": false,
+		"": false,
+	} {
+		if got := isUuid(s); got != want {
+			t.Errorf("%q: got %v, want %v", s, got, want)
+		}
+	}
+}
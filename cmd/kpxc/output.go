@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	formatPlain string = "plain"
+	formatTable string = "table"
+	formatJson  string = "json"
+)
+
+// output renders a result in the format chosen with -format. Every command
+// hands over the value for JSON, the rows for tables and the lines for
+// plain output, which is meant for scripts.
+type output struct {
+	format string
+	w      io.Writer
+}
+
+type result struct {
+	value  interface{}
+	header []string
+	rows   [][]string
+	plain  []string
+}
+
+func checkFormat(format string) (err error) {
+	switch format {
+	case formatPlain, formatTable, formatJson:
+		return nil
+	}
+
+	return fmt.Errorf("Unknown output format: %s", format)
+}
+
+func (o *output) emit(r *result) (err error) {
+	switch o.format {
+	case formatJson:
+		enc := json.NewEncoder(o.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r.value)
+	case formatTable:
+		tw := tabwriter.NewWriter(o.w, 0, 8, 2, ' ', 0)
+		if len(r.header) > 0 {
+			fmt.Fprintln(tw, strings.Join(r.header, "\t"))
+		}
+		for _, row := range r.rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	}
+
+	for _, line := range r.plain {
+		if _, err = fmt.Fprintln(o.w, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
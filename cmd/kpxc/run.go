@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	kpxc "gitea.olznet.de/OlzNet/golang-keepassxc-browser"
+)
+
+// exitStatus passes the exit code of the command started by run on.
+type exitStatus struct {
+	code int
+}
+
+func (e *exitStatus) Error() string {
+	return fmt.Sprintf("Command exited with status %d", e.code)
+}
+
+// assignments collects repeated VAR=reference flags.
+type assignments []string
+
+func (a *assignments) String() string {
+	return strings.Join(*a, ",")
+}
+
+func (a *assignments) Set(v string) (err error) {
+	name, ref, ok := strings.Cut(v, "=")
+	if !ok || name == "" || ref == "" {
+		return fmt.Errorf("Expected VAR=reference, got: %s", v)
+	}
+	if _, err = kpxc.ParseRef(ref); err != nil {
+		return err
+	}
+	*a = append(*a, v)
+
+	return nil
+}
+
+func flagsRun(fs *flag.FlagSet) {
+	fs.Var(new(assignments), "env", "set `VAR=reference` in the environment of the command, repeatable")
+	fs.Var(new(assignments), "file", "write the secret of `VAR=reference` to a temporary file and set VAR to its path, repeatable")
+}
+
+// writeSecretFile creates the file readable by the user only, dir is
+// private to the run as well.
+func writeSecretFile(dir, name string, secret kpxc.Secret) (ret string, err error) {
+	ret = filepath.Join(dir, name)
+	f, err := os.OpenFile(ret, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	if _, err = f.Write(secret); err != nil {
+		f.Close()
+		return "", err
+	}
+
+	return ret, f.Close()
+}
+
+// removeSecretFiles overwrites the files before removing them.
+func removeSecretFiles(dir string) {
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		if info, err := e.Info(); err == nil {
+			os.WriteFile(path, make([]byte, info.Size()), 0600)
+		}
+	}
+	os.RemoveAll(dir)
+}
+
+func cmdRun(s *session, args []string) (ret *result, err error) {
+	if len(args) == 0 {
+		return nil, &usageError{"No command given"}
+	}
+	envs := *s.flags.Lookup("env").Value.(*assignments)
+	files := *s.flags.Lookup("file").Value.(*assignments)
+
+	var refs []string
+	for _, a := range append(envs, files...) {
+		_, ref, _ := strings.Cut(a, "=")
+		refs = append(refs, ref)
+	}
+	r, err := kpxc.NewResolver(s.client, kpxc.WithCacheTTL(0))
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := r.ResolveAll(context.Background(), refs)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, secret := range secrets {
+			secret.Wipe()
+		}
+	}()
+
+	env := os.Environ()
+	for _, a := range envs {
+		name, ref, _ := strings.Cut(a, "=")
+		env = append(env, name+"="+string(secrets[ref]))
+	}
+	if len(files) > 0 {
+		dir, err := os.MkdirTemp("", "kpxc-run-")
+		if err != nil {
+			return nil, err
+		}
+		defer removeSecretFiles(dir)
+		for _, a := range files {
+			name, ref, _ := strings.Cut(a, "=")
+			path, err := writeSecretFile(dir, name, secrets[ref])
+			if err != nil {
+				return nil, err
+			}
+			env = append(env, name+"="+path)
+		}
+	}
+	// the command may run for long, do not keep the session open meanwhile
+	s.close()
+
+	return nil, runCommand(args, env)
+}
+
+// forwardSignals are passed on to the command. The terminal sends
+// groupSignals to the whole foreground process group, the command got
+// them already, they are only caught to remove the files once it is done.
+var forwardSignals = []os.Signal{syscall.SIGTERM}
+var groupSignals = []os.Signal{os.Interrupt, syscall.SIGQUIT, syscall.SIGHUP}
+
+// runCommand runs args with env, a failed command is returned as
+// *exitStatus.
+func runCommand(args, env []string) (err error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, append(forwardSignals, groupSignals...)...)
+	defer func() {
+		signal.Stop(sigs)
+		close(sigs)
+	}()
+	go func() {
+		for sig := range sigs {
+			for _, f := range forwardSignals {
+				if sig == f {
+					cmd.Process.Signal(sig)
+				}
+			}
+		}
+	}()
+
+	err = cmd.Wait()
+	var xerr *exec.ExitError
+	if errors.As(err, &xerr) {
+		code := xerr.ExitCode()
+		if code < 0 {
+			// killed by a signal
+			code = 128
+			if ws, ok := xerr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+				code += int(ws.Signal())
+			}
+		}
+		return &exitStatus{code}
+	}
+
+	return err
+}
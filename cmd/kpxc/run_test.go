@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	kpxc "gitea.olznet.de/OlzNet/golang-keepassxc-browser"
+)
+
+func TestAssignments(t *testing.T) {
+	var a assignments
+	for _, v := range []string{"TOKEN=kpxc://ci.internal/KPH:token", "PW=kpxc://admin@db.internal/password"} {
+		if err := a.Set(v); err != nil {
+			t.Errorf("%s: %v", v, err)
+		}
+	}
+	if len(a) != 2 {
+		t.Errorf("got %v", a)
+	}
+	for _, v := range []string{"TOKEN", "=kpxc://db.internal/password", "PW=", "PW=https://db.internal/password", "PW=kpxc://db.internal/secret"} {
+		if err := a.Set(v); err == nil {
+			t.Errorf("%s accepted", v)
+		}
+	}
+}
+
+func TestSecretFiles(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "run")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	path, err := writeSecretFile(dir, "PW", kpxc.Secret("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("mode %o, want 600", fi.Mode().Perm())
+	}
+	if _, err = writeSecretFile(dir, "PW", kpxc.Secret("other")); err == nil {
+		t.Error("existing file overwritten")
+	}
+
+	removeSecretFiles(dir)
+	if _, err = os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("directory left: %v", err)
+	}
+}
+
+func TestRunCommandExit(t *testing.T) {
+	if err := runCommand([]string{"sh", "-c", "exit 0"}, nil); err != nil {
+		t.Errorf("got %v", err)
+	}
+	var xerr *exitStatus
+	if err := runCommand([]string{"sh", "-c", "exit 3"}, nil); !errors.As(err, &xerr) || xerr.code != 3 {
+		t.Errorf("got %v, want status 3", err)
+	}
+	if err := runCommand([]string{"sh", "-c", "kill -KILL $$"}, nil); !errors.As(err, &xerr) || xerr.code != 128+int(syscall.SIGKILL) {
+		t.Errorf("got %v, want status %d", err, 128+int(syscall.SIGKILL))
+	}
+	if err := runCommand([]string{filepath.Join(t.TempDir(), "missing")}, nil); err == nil || errors.As(err, &xerr) {
+		t.Errorf("got %v, want a start error", err)
+	}
+}
+
+func TestRunCommandSignals(t *testing.T) {
+	ready := filepath.Join(t.TempDir(), "ready")
+	script := `trap 'exit 5' INT; trap 'exit 7' TERM; touch "$READY"; while :; do sleep 0.05; done`
+	done := make(chan error, 1)
+	go func() {
+		done <- runCommand([]string{"sh", "-c", script}, append(os.Environ(), "READY="+ready))
+	}()
+	for i := 0; ; i++ {
+		if _, err := os.Stat(ready); err == nil {
+			break
+		}
+		if i == 200 {
+			t.Fatal("command did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// SIGINT reaches the command from the terminal, it is not passed on
+	syscall.Kill(os.Getpid(), syscall.SIGINT)
+	time.Sleep(200 * time.Millisecond)
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+
+	select {
+	case err := <-done:
+		var xerr *exitStatus
+		if !errors.As(err, &xerr) || xerr.code != 7 {
+			t.Errorf("got %v, want status 7 of SIGTERM only", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("command not ended by SIGTERM")
+	}
+}
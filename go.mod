@@ -1,5 +1,16 @@
 module gitea.olznet.de/OlzNet/golang-keepassxc-browser
 
-go 1.18
+go 1.21
 
-require github.com/jamesruan/sodium v1.0.14
+require (
+	github.com/jamesruan/sodium v1.0.14
+	github.com/tobischo/gokeepasslib/v3 v3.4.1
+	golang.org/x/crypto v0.17.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/aead/argon2 v0.0.0-20180111183520-a87724528b07 // indirect
+	github.com/aead/chacha20 v0.0.0-20180709150244-8b13a72661da // indirect
+	golang.org/x/sys v0.15.0 // indirect
+)
@@ -0,0 +1,160 @@
+package keepassxc_browser
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// AssocFileName is the file command line tools keep their identity in,
+// below the user configuration directory.
+const AssocFileName string = "kpxc/association.json"
+
+// DefaultAssocFile returns the path of AssocFileName in the user
+// configuration directory, e.g. ~/.config/kpxc/association.json.
+func DefaultAssocFile() (ret string, err error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, AssocFileName), nil
+}
+
+type Association struct {
+	Id    string `json:"id"`
+	IdKey string `json:"idKey"`
+}
+
+func generateIdKey() (ret string, err error) {
+	kp, err := MakeBoxKP()
+	if err != nil {
+		return "", err
+	}
+	wipeBytes(kp.SecretKey.Bytes)
+
+	return base64.StdEncoding.EncodeToString(kp.PublicKey.Bytes), nil
+}
+
+func (c *Client) storeAssoc(hash string, assoc Association) {
+	if hash == "" {
+		return
+	}
+	if c.Associations == nil {
+		c.Associations = make(map[string]Association)
+	}
+	c.Associations[hash] = assoc
+}
+
+// forgetAssoc drops the association of the database hash, e.g. after
+// KeePassXC rejected it, and deselects it if it is the current one. The
+// identity key is kept for associating again.
+func (c *Client) forgetAssoc(hash string) {
+	assoc, ok := c.Associations[hash]
+	if !ok {
+		return
+	}
+	delete(c.Associations, hash)
+	if c.AId == assoc.Id {
+		c.AId = ""
+	}
+}
+
+// UseAssoc makes the association stored for the database hash the current
+// one. It returns false if the database was never associated.
+func (c *Client) UseAssoc(hash string) bool {
+	assoc, ok := c.Associations[hash]
+	if !ok {
+		return false
+	}
+	c.AId, c.IdKey = assoc.Id, assoc.IdKey
+
+	return true
+}
+
+// keys returns the current association followed by the ones stored for
+// other databases, so get-logins can search all of them.
+func (c *Client) keys() (ret []key) {
+	seen := make(map[string]bool)
+	if c.AId != "" {
+		ret = append(ret, key{Id: c.AId, Key: c.IdKey})
+		seen[c.AId] = true
+	}
+	for _, a := range c.Associations {
+		if seen[a.Id] {
+			continue
+		}
+		ret = append(ret, key{Id: a.Id, Key: a.IdKey})
+		seen[a.Id] = true
+	}
+
+	return ret
+}
+
+// RotateIdentity replaces the identity key of the associated databases.
+// KeePassXC only associates the active database, so every call moves the
+// active one to the new key: it is associated and verified with
+// test-associate before the old key is retired, on failure the old
+// association stays in place. The new key is kept in NextIdKey until all
+// stored databases use it, stale lists the ones still on their old key.
+// Activate each of them in KeePassXC and call RotateIdentity again, saving
+// the associations in between.
+func (c *Client) RotateIdentity() (ret *MsgAssociate, stale []string, err error) {
+	dbhash, err := c.GetDatabasehash()
+	if err != nil {
+		return nil, c.staleAssocs(), err
+	}
+
+	oldIdKey, oldAId := c.IdKey, c.AId
+	oldAssoc, hadAssoc := c.Associations[dbhash.Hash]
+	restore := func() {
+		c.IdKey, c.AId = oldIdKey, oldAId
+		if hadAssoc {
+			c.Associations[dbhash.Hash] = oldAssoc
+		} else {
+			delete(c.Associations, dbhash.Hash)
+		}
+	}
+
+	if c.NextIdKey == "" {
+		if c.NextIdKey, err = generateIdKey(); err != nil {
+			return nil, c.staleAssocs(), err
+		}
+	}
+	c.IdKey = c.NextIdKey
+
+	if ret, err = c.Associate(); err != nil {
+		restore()
+		return nil, c.staleAssocs(), err
+	}
+
+	if _, err = c.TestAssociate(); err != nil {
+		restore()
+		return nil, c.staleAssocs(), fmt.Errorf("Unable to verify new association: %v", err)
+	}
+
+	c.storeAssoc(dbhash.Hash, Association{Id: c.AId, IdKey: c.IdKey})
+	if stale = c.staleAssocs(); len(stale) == 0 {
+		c.NextIdKey = ""
+	}
+
+	return ret, stale, nil
+}
+
+// staleAssocs returns the hashes of the databases not yet rotated to
+// NextIdKey.
+func (c *Client) staleAssocs() (ret []string) {
+	if c.NextIdKey == "" {
+		return nil
+	}
+	for hash, a := range c.Associations {
+		if a.IdKey != c.NextIdKey {
+			ret = append(ret, hash)
+		}
+	}
+	sort.Strings(ret)
+
+	return ret
+}
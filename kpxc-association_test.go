@@ -0,0 +1,109 @@
+package keepassxc_browser
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// twoDbBackend serves two databases, only the active one answers like
+// KeePassXC does.
+type twoDbBackend struct {
+	stubBackend
+	active string
+	keys   map[string]string
+	// failTest rejects every test-associate
+	failTest bool
+}
+
+func (b *twoDbBackend) GetDatabasehash(clientId string, req *MsgGetDatabasehash) (*MsgGetDatabasehash, error) {
+	return &MsgGetDatabasehash{MsgBase{Hash: b.active}}, nil
+}
+
+func (b *twoDbBackend) Associate(clientId string, req *MsgAssociate) (*MsgAssociate, error) {
+	b.keys[b.active] = req.IdKey
+	return &MsgAssociate{MsgBase: MsgBase{Id: "id-" + b.active, Hash: b.active}}, nil
+}
+
+func (b *twoDbBackend) TestAssociate(clientId string, req *MsgAssociate) (*MsgAssociate, error) {
+	if b.failTest || req.Id != "id-"+b.active || req.Key == "" || req.Key != b.keys[b.active] {
+		return nil, NewProtocolError(ErrCodeAssociationFailed, "Association failed")
+	}
+	return &MsgAssociate{MsgBase: MsgBase{Id: req.Id, Hash: b.active}}, nil
+}
+
+func TestRotateIdentity(t *testing.T) {
+	backend := &twoDbBackend{active: "a", keys: make(map[string]string)}
+	c := newStubClient(t, backend)
+	oldKey := c.IdKey
+	for _, db := range []string{"a", "b"} {
+		backend.active = db
+		if _, err := c.Associate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a failed verification keeps the old association
+	backend.active = "a"
+	backend.failTest = true
+	if _, _, err := c.RotateIdentity(); err == nil {
+		t.Fatal("rotated without verifying")
+	}
+	if c.IdKey != oldKey || c.Associations["a"].IdKey != oldKey {
+		t.Error("old association not restored")
+	}
+	backend.failTest = false
+
+	_, stale, err := c.RotateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey := c.NextIdKey
+	if newKey == "" || newKey == oldKey {
+		t.Fatalf("no new identity key: %q", newKey)
+	}
+	if !reflect.DeepEqual(stale, []string{"b"}) {
+		t.Errorf("stale %v, want [b]", stale)
+	}
+	if c.Associations["a"].IdKey != newKey || backend.keys["a"] != newKey {
+		t.Error("database a not rotated")
+	}
+	if c.Associations["b"].IdKey != oldKey {
+		t.Error("database b rotated while inactive")
+	}
+
+	// the rotation continues with the saved associations
+	file := filepath.Join(t.TempDir(), "assoc.json")
+	if err = c.SaveAssoc(file); err != nil {
+		t.Fatal(err)
+	}
+	c = newStubClient(t, backend)
+	if err = c.LoadAssoc(file); err != nil {
+		t.Fatal(err)
+	}
+	backend.active = "b"
+	if !c.UseAssoc("b") {
+		t.Fatal("association of b not loaded")
+	}
+	if _, stale, err = c.RotateIdentity(); err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 0 || c.NextIdKey != "" {
+		t.Errorf("rotation not finished: stale %v, next %q", stale, c.NextIdKey)
+	}
+	for _, db := range []string{"a", "b"} {
+		if c.Associations[db].IdKey != newKey || backend.keys[db] != newKey {
+			t.Errorf("database %s not on the new key", db)
+		}
+	}
+	if c.IdKey != newKey {
+		t.Error("client not on the new key")
+	}
+	for _, db := range []string{"a", "b"} {
+		backend.active = db
+		c.UseAssoc(db)
+		if _, err = c.TestAssociate(); err != nil {
+			t.Errorf("test-associate %s: %v", db, err)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package keepassxc_browser
+
+import (
+	"os"
+)
+
+// lockFile is a no-op without flock, an audit log must not be shared
+// between processes there.
+func lockFile(f *os.File) (unlock func(), err error) {
+	return func() {}, nil
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package keepassxc_browser
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive flock on f, so processes sharing one audit
+// log append their records one after another.
+func lockFile(f *os.File) (unlock func(), err error) {
+	fd := int(f.Fd())
+	for {
+		if err = syscall.Flock(fd, syscall.LOCK_EX); err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return func() { syscall.Flock(fd, syscall.LOCK_UN) }, nil
+}
@@ -0,0 +1,319 @@
+package keepassxc_browser
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const AuditSuccess string = "success"
+const AuditDenied string = "denied"
+const AuditError string = "error"
+
+// AuditPending is the outcome of the record written before a request is
+// forwarded, the record with the final outcome follows.
+const AuditPending string = "pending"
+
+// AuditRecord is one line of the audit log. It names the entries a client
+// got, never their secrets.
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	ClientId  string    `json:"clientID"`
+	Action    string    `json:"action"`
+	RequestId string    `json:"requestID,omitempty"`
+	Url       string    `json:"url,omitempty"`
+	Entries   []string  `json:"entries,omitempty"`
+	Outcome   string    `json:"outcome"`
+	ErrorCode string    `json:"errorCode,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Prev      string    `json:"prev,omitempty"`
+	Hash      string    `json:"hash,omitempty"`
+}
+
+// AuditLog appends AuditRecords as JSON lines. With chaining every record
+// holds the hash of its predecessor and its own hash over both, so removed
+// or altered lines are found by VerifyAuditLog. A plain SHA-256 chain can
+// be recomputed by anybody able to write the log, with WithAuditKey the
+// hashes are HMACs only the key holders can compute.
+type AuditLog struct {
+	mu    sync.Mutex
+	w     io.Writer
+	f     *os.File
+	chain bool
+	key   []byte
+	last  string
+}
+
+// AuditOption configures an AuditLog and VerifyAuditLog.
+type AuditOption func(l *AuditLog)
+
+// WithAuditKey chains the records with HMAC-SHA256 under key instead of
+// SHA-256, the same key is needed to verify them.
+func WithAuditKey(key []byte) AuditOption {
+	return func(l *AuditLog) {
+		l.key = key
+	}
+}
+
+// ReadAuditKey reads the key for WithAuditKey from file, surrounding white
+// space is dropped.
+func ReadAuditKey(file string) (ret []byte, err error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if ret = bytes.TrimSpace(data); len(ret) == 0 {
+		return nil, fmt.Errorf("Empty audit key: %s", file)
+	}
+
+	return ret, nil
+}
+
+func (r *AuditRecord) digest(key []byte) (ret string, err error) {
+	c := *r
+	c.Hash = ""
+	data, err := json.Marshal(&c)
+	if err != nil {
+		return "", err
+	}
+	if key != nil {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(data)
+		return hex.EncodeToString(mac.Sum(nil)), nil
+	}
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func newAuditRecord(req *ConnMsg) (ret *AuditRecord) {
+	ret = &AuditRecord{
+		ClientId:  req.ClientId,
+		Action:    req.ActionName,
+		RequestId: req.RequestId,
+	}
+	if req.data != nil {
+		ret.Url, _ = requestUrl(req)
+		switch d := req.data.(type) {
+		case *MsgSetLogin:
+			if d.Uuid != "" {
+				ret.Entries = []string{d.Uuid}
+			}
+		case *MsgGetTotp:
+			ret.Entries = []string{d.Uuid}
+		case *MsgDeleteEntry:
+			ret.Entries = []string{d.Uuid}
+		}
+	}
+
+	return ret
+}
+
+// result records the outcome of res, data the decrypted response if known.
+func (r *AuditRecord) result(res *ConnMsg, data MsgI) {
+	if res.Error != "" || res.ErrorCode != "" {
+		r.Outcome = AuditError
+		r.ErrorCode = res.ErrorCode
+		r.Error = res.Error
+		return
+	}
+	r.Outcome = AuditSuccess
+	if d, ok := data.(*MsgGetLogins); ok {
+		r.Entries = nil
+		for _, e := range d.Entries {
+			r.Entries = append(r.Entries, e.Uuid)
+		}
+	}
+}
+
+func (r *AuditRecord) fail(err error) {
+	r.Outcome = AuditError
+	r.Error = err.Error()
+	if perr, ok := err.(*ProtocolError); ok {
+		r.Error = perr.Message
+		r.ErrorCode = fmt.Sprint(perr.Code)
+		if perr.Code == ErrCodeActionCancelledOrDenied {
+			r.Outcome = AuditDenied
+		}
+	}
+}
+
+// Write appends rec, chaining it to the previous record if enabled. A log
+// opened by OpenAuditLog is locked while appending and the chain continues
+// from its last record, so other processes may append to the same file.
+func (l *AuditLog) Write(rec *AuditRecord) (err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if rec.Time.IsZero() {
+		rec.Time = time.Now().UTC()
+	}
+	if l.f != nil {
+		unlock, err := lockFile(l.f)
+		if err != nil {
+			return fmt.Errorf("Unable to lock audit log: %v", err)
+		}
+		defer unlock()
+		if l.chain {
+			if l.last, err = lastRecordHash(l.f); err != nil {
+				return fmt.Errorf("Unable to continue hash chain: %v", err)
+			}
+		}
+	}
+	if l.chain {
+		rec.Prev = l.last
+		if rec.Hash, err = rec.digest(l.key); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err = l.w.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	l.last = rec.Hash
+
+	return nil
+}
+
+// writePending records rec as AuditPending before its request is
+// forwarded, so nothing is passed on which is not in the log.
+func (l *AuditLog) writePending(rec *AuditRecord) (err error) {
+	p := *rec
+	p.Outcome = AuditPending
+	p.ErrorCode = ""
+	p.Error = ""
+
+	return l.Write(&p)
+}
+
+func (l *AuditLog) Close() (err error) {
+	if l.f != nil {
+		return l.f.Close()
+	}
+	return nil
+}
+
+// lastRecordHash returns the hash of the last record in f. Only the tail of
+// the file is read, a record is never longer than 16*BufSize.
+func lastRecordHash(f *os.File) (ret string, err error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	size := fi.Size()
+	off := size - int64(16*BufSize)
+	if off < 0 {
+		off = 0
+	}
+	tail := make([]byte, size-off)
+	if _, err = f.ReadAt(tail, off); err != nil && err != io.EOF {
+		return "", err
+	}
+	if off > 0 {
+		// drop the partial line the tail starts in
+		if i := bytes.IndexByte(tail, '\n'); i >= 0 {
+			tail = tail[i+1:]
+		}
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(tail), []byte{'\n'})
+	line := bytes.TrimSpace(lines[len(lines)-1])
+	if len(line) == 0 {
+		return "", nil
+	}
+	var rec AuditRecord
+	if err = json.Unmarshal(line, &rec); err != nil {
+		return "", err
+	}
+
+	return rec.Hash, nil
+}
+
+// OpenAuditLog opens file for appending, it is created readable by the
+// owner only.
+func OpenAuditLog(file string, chain bool, opts ...AuditOption) (ret *AuditLog, err error) {
+	ret = new(AuditLog)
+	ret.chain = chain
+	for _, opt := range opts {
+		opt(ret)
+	}
+	// read for the last hash, see Write
+	if ret.f, err = os.OpenFile(file, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600); err != nil {
+		return nil, err
+	}
+	ret.w = ret.f
+	if chain {
+		if ret.last, err = lastRecordHash(ret.f); err != nil {
+			ret.f.Close()
+			return nil, fmt.Errorf("Unable to continue hash chain: %v", err)
+		}
+	}
+
+	return ret, nil
+}
+
+// NewAuditLog writes the records to w, e.g. a syslog writer.
+func NewAuditLog(w io.Writer, chain bool, opts ...AuditOption) (ret *AuditLog) {
+	ret = &AuditLog{w: w, chain: chain}
+	for _, opt := range opts {
+		opt(ret)
+	}
+
+	return ret
+}
+
+// VerifyAuditLog checks the hash chain of the records read from r and
+// returns their number, pass WithAuditKey for logs written with a key. The
+// first record may continue a chain whose start was rotated away, so only
+// records removed or altered after the first one are found: records cut
+// off at the start or the end of the log go unnoticed. Without a key the
+// chain only shows accidental damage, whoever can edit the log can
+// recompute it.
+func VerifyAuditLog(r io.Reader, opts ...AuditOption) (n int, err error) {
+	var l AuditLog
+	for _, opt := range opts {
+		opt(&l)
+	}
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, BufSize), 16*BufSize)
+	last := ""
+	for line := 1; s.Scan(); line++ {
+		data := bytes.TrimSpace(s.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		var rec AuditRecord
+		if err = json.Unmarshal(data, &rec); err != nil {
+			return n, fmt.Errorf("Line %d: %v", line, err)
+		}
+		if rec.Hash == "" {
+			return n, fmt.Errorf("Line %d: record not chained", line)
+		}
+		if n > 0 && rec.Prev != last {
+			return n, fmt.Errorf("Line %d: chain broken, previous record missing or altered", line)
+		}
+		sum, err := rec.digest(l.key)
+		if err != nil {
+			return n, err
+		}
+		if sum != rec.Hash {
+			return n, fmt.Errorf("Line %d: hash mismatch, record altered", line)
+		}
+		last = rec.Hash
+		n++
+	}
+
+	return n, s.Err()
+}
@@ -0,0 +1,167 @@
+package keepassxc_browser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("Disk full")
+}
+
+func auditRecords(t *testing.T, data []byte) (ret []AuditRecord) {
+	t.Helper()
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		var rec AuditRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			t.Fatal(err)
+		}
+		ret = append(ret, rec)
+	}
+	return ret
+}
+
+func TestKpXcProxyAuditPending(t *testing.T) {
+	var forwarded int32
+	path := testSocket(t, func(msg []byte) []byte {
+		atomic.AddInt32(&forwarded, 1)
+		return msg
+	})
+	proxy, err := NewKpXcProxy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy.dialer = newUpstreamDialer(path, nil)
+	defer proxy.Close()
+	req := []byte(`{"action":"get-databasehash","requestID":"1"}`)
+
+	// nothing is forwarded without a record
+	proxy.SetAuditLog(NewAuditLog(failWriter{}, true))
+	res, err := proxy.HandleReq(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(res), `"errorCode":"6"`) {
+		t.Errorf("got %s, want a denial", res)
+	}
+	if n := atomic.LoadInt32(&forwarded); n != 0 {
+		t.Errorf("%d requests forwarded without audit record", n)
+	}
+
+	var buf bytes.Buffer
+	proxy.SetAuditLog(NewAuditLog(&buf, true))
+	if _, err = proxy.HandleReq(req); err != nil {
+		t.Fatal(err)
+	}
+	recs := auditRecords(t, buf.Bytes())
+	if len(recs) != 2 || recs[0].Outcome != AuditPending || recs[1].Outcome != AuditSuccess {
+		t.Errorf("got records %+v, want pending and success", recs)
+	}
+	if n, err := VerifyAuditLog(&buf); err != nil || n != 2 {
+		t.Errorf("VerifyAuditLog: %d records, %v", n, err)
+	}
+}
+
+func TestVerifyAuditLogKey(t *testing.T) {
+	key := []byte("secret")
+	var buf bytes.Buffer
+	l := NewAuditLog(&buf, true, WithAuditKey(key))
+	for _, action := range []string{"get-databasehash", "get-logins", "lock-database"} {
+		if err := l.Write(&AuditRecord{Action: action, Outcome: AuditSuccess}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	log := buf.String()
+
+	if n, err := VerifyAuditLog(strings.NewReader(log), WithAuditKey(key)); err != nil || n != 3 {
+		t.Errorf("with key: %d records, %v", n, err)
+	}
+	for _, opts := range [][]AuditOption{nil, {WithAuditKey([]byte("other"))}} {
+		if _, err := VerifyAuditLog(strings.NewReader(log), opts...); err == nil {
+			t.Error("verified without the right key")
+		}
+	}
+
+	// a record altered and rehashed without the key
+	lines := strings.SplitAfter(log, "\n")
+	var rec AuditRecord
+	json.Unmarshal([]byte(lines[1]), &rec)
+	rec.Action = "get-totp"
+	rec.Hash, _ = rec.digest(nil)
+	data, _ := json.Marshal(&rec)
+	lines[1] = string(data) + "\n"
+	if _, err := VerifyAuditLog(strings.NewReader(strings.Join(lines, "")), WithAuditKey(key)); err == nil {
+		t.Error("altered record verified")
+	}
+
+	// the start of the log is not covered
+	if n, err := VerifyAuditLog(strings.NewReader(strings.Join(lines[2:], "")), WithAuditKey(key)); err != nil || n != 1 {
+		t.Errorf("truncated log: %d records, %v", n, err)
+	}
+}
+
+func TestOpenAuditLogShared(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.log")
+	var logs []*AuditLog
+	for i := 0; i < 2; i++ {
+		l, err := OpenAuditLog(file, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		logs = append(logs, l)
+	}
+
+	// like two processes appending to one log
+	for i := 0; i < 6; i++ {
+		if err := logs[i%2].Write(&AuditRecord{Action: "get-logins", Outcome: AuditSuccess}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if n, err := VerifyAuditLog(f); err != nil || n != 6 {
+		t.Errorf("VerifyAuditLog: %d records, %v", n, err)
+	}
+}
+
+func TestKpXcMitmAuditUrl(t *testing.T) {
+	path, _ := browserSocket(t)
+	mitm, err := NewKpXcMitm(&UrlRewriteModifier{Hosts: map[string]string{"intranet": "https://intranet.example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mitm.modifier.dialer = newUpstreamDialer(path, nil)
+	defer mitm.Close()
+	var buf bytes.Buffer
+	mitm.SetAuditLog(NewAuditLog(&buf, false))
+
+	c := newMitmClient(t, mitm.NewClient(), "c")
+	c.GetLogins("https://intranet/login", "", "")
+
+	var urls []string
+	for _, rec := range auditRecords(t, buf.Bytes()) {
+		if rec.Action == "get-logins" {
+			urls = append(urls, rec.Url)
+		}
+	}
+	if len(urls) == 0 {
+		t.Fatal("get-logins not recorded")
+	}
+	for _, u := range urls {
+		if u != "https://intranet/login" {
+			t.Errorf("recorded %s, want the URL the client asked for", u)
+		}
+	}
+}
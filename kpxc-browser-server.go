@@ -0,0 +1,189 @@
+package keepassxc_browser
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// ServerVersion is reported as KeePassXC version to the clients.
+var ServerVersion string = "2.7.6"
+
+// BrowserBackend serves the decrypted, typed requests of a BrowserServer.
+// Returning a *ProtocolError sends its code to the client, any other error
+// is reported as ErrCodeActionCancelledOrDenied. The returned messages are
+// wiped once sent, so they must not share Secrets with the backend.
+type BrowserBackend interface {
+	GetDatabasehash(clientId string, req *MsgGetDatabasehash) (*MsgGetDatabasehash, error)
+	Associate(clientId string, req *MsgAssociate) (*MsgAssociate, error)
+	TestAssociate(clientId string, req *MsgAssociate) (*MsgAssociate, error)
+	GeneratePassword(clientId string, req *MsgGeneratePassword) (*MsgGeneratePassword, error)
+	GetLogins(clientId string, req *MsgGetLogins) (*MsgGetLogins, error)
+	SetLogin(clientId string, req *MsgSetLogin) (*MsgSetLogin, error)
+	LockDatabase(clientId string, req *MsgLockDatabase) (*MsgLockDatabase, error)
+	GetDatabaseGroups(clientId string, req *MsgGetDatabaseGroups) (*MsgGetDatabaseGroups, error)
+	CreateNewGroup(clientId string, req *MsgCreateNewGroup) (*MsgCreateNewGroup, error)
+	GetTotp(clientId string, req *MsgGetTotp) (*MsgGetTotp, error)
+}
+
+// BrowserServer is a ServerI speaking the KeePassXC browser protocol. It
+// does the key exchange and message encryption and hands the requests to
+// a BrowserBackend. Use one BrowserServer per client connection. Like
+// KeePassXC it only passes the actions of unassociatedActions on until the
+// client succeeded with associate or test-associate on the connection.
+type BrowserServer struct {
+	backend      BrowserBackend
+	keyPair      BoxKP
+	clientPubKey BoxPublicKey
+	associated   bool
+}
+
+// unassociatedActions are the actions a client may use before it is
+// associated, change-public-keys is handled by the BrowserServer itself.
+var unassociatedActions = map[string]bool{
+	"get-databasehash":  true,
+	"associate":         true,
+	"test-associate":    true,
+	"generate-password": true,
+}
+
+func (s *BrowserServer) errorReply(req *ConnMsg, err error) (ret []byte, rerr error) {
+	return ErrorReply(req, err)
+}
+
+func (s *BrowserServer) changePublicKeys(req *ConnMsg) (ret []byte, err error) {
+	if req.PublicKey == "" {
+		return s.errorReply(req, NewProtocolError(ErrCodeClientPublicKeyNotReceived, "Client public key not received"))
+	}
+	pubKey, err := base64.StdEncoding.DecodeString(req.PublicKey)
+	if err != nil || len(pubKey) != BoxKeySize {
+		return s.errorReply(req, NewProtocolError(ErrCodeKeyChangeFailed, "Key change was not successful"))
+	}
+	s.clientPubKey.Bytes = pubKey
+	// a new key exchange starts a new session
+	s.associated = false
+
+	nonce := BoxNonce{Bytes: append([]byte(nil), req.nonce.Bytes...)}
+	nonce.Next()
+	res := &ConnMsg{
+		ActionName: req.ActionName,
+		RequestId:  req.RequestId,
+		Nonce:      base64.StdEncoding.EncodeToString(nonce.Bytes),
+		PublicKey:  base64.StdEncoding.EncodeToString(s.keyPair.PublicKey.Bytes),
+		Success:    "true",
+		Version:    ServerVersion,
+	}
+
+	return json.Marshal(res)
+}
+
+func (s *BrowserServer) dispatch(clientId string, req MsgI) (ret MsgI, err error) {
+	b := s.backend
+	switch r := req.(type) {
+	case *MsgGetDatabasehash:
+		return b.GetDatabasehash(clientId, r)
+	case *MsgAssociate:
+		if r.ActionName == "test-associate" {
+			return b.TestAssociate(clientId, r)
+		}
+		return b.Associate(clientId, r)
+	case *MsgGeneratePassword:
+		return b.GeneratePassword(clientId, r)
+	case *MsgGetLogins:
+		return b.GetLogins(clientId, r)
+	case *MsgSetLogin:
+		return b.SetLogin(clientId, r)
+	case *MsgLockDatabase:
+		return b.LockDatabase(clientId, r)
+	case *MsgGetDatabaseGroups:
+		return b.GetDatabaseGroups(clientId, r)
+	case *MsgCreateNewGroup:
+		return b.CreateNewGroup(clientId, r)
+	case *MsgGetTotp:
+		return b.GetTotp(clientId, r)
+	}
+
+	return nil, NewProtocolError(ErrCodeIncorrectAction, "Incorrect action")
+}
+
+func (s *BrowserServer) HandleReq(breq []byte) (bres []byte, err error) {
+	req, err := ParseConnMsg(breq)
+	if err != nil {
+		// still answer unknown actions
+		req = new(ConnMsg)
+		if jerr := json.Unmarshal(breq, req); jerr != nil {
+			return nil, err
+		}
+		return s.errorReply(req, NewProtocolError(ErrCodeIncorrectAction, "Incorrect action"))
+	}
+
+	if req.ActionName == "change-public-keys" {
+		return s.changePublicKeys(req)
+	}
+
+	if s.clientPubKey.Bytes == nil {
+		return s.errorReply(req, NewProtocolError(ErrCodeClientPublicKeyNotReceived, "Client public key not received"))
+	}
+	if req.Message == "" || req.data == nil {
+		return s.errorReply(req, NewProtocolError(ErrCodeEmptyMessageReceived, "Empty message received"))
+	}
+
+	jedata, err := base64.StdEncoding.DecodeString(req.Message)
+	if err != nil {
+		return s.errorReply(req, NewProtocolError(ErrCodeCannotDecryptMessage, "Cannot decrypt message"))
+	}
+	jdata, err := DecryptBytes(req.nonce, s.clientPubKey, s.keyPair.SecretKey, jedata)
+	if err != nil {
+		return s.errorReply(req, NewProtocolError(ErrCodeCannotDecryptMessage, "Cannot decrypt message"))
+	}
+	err = json.Unmarshal(jdata, req.data)
+	wipeBytes(jdata)
+	if err != nil {
+		return s.errorReply(req, NewProtocolError(ErrCodeCannotDecryptMessage, "Cannot decrypt message"))
+	}
+	defer req.Wipe()
+
+	if !s.associated && !unassociatedActions[req.ActionName] {
+		return s.errorReply(req, NewProtocolError(ErrCodeAssociationFailed, "Association was not successful"))
+	}
+	res, err := s.dispatch(req.ClientId, req.data)
+	if err != nil {
+		return s.errorReply(req, err)
+	}
+	if res == nil || reflect.ValueOf(res).IsNil() {
+		return s.errorReply(req, NewProtocolError(ErrCodeActionCancelledOrDenied, "No response"))
+	}
+	defer res.Wipe()
+	if _, ok := res.(*MsgAssociate); ok {
+		s.associated = true
+	}
+
+	return s.reply(req, res)
+}
+
+func (s *BrowserServer) reply(req *ConnMsg, data MsgI) (ret []byte, err error) {
+	return encryptedReply(req, data, s.keyPair, s.clientPubKey)
+}
+
+func NewBrowserServer(backend BrowserBackend) (ret *BrowserServer, err error) {
+	if backend == nil {
+		return nil, fmt.Errorf("Backend required")
+	}
+
+	ret = new(BrowserServer)
+	ret.backend = backend
+	if ret.keyPair, err = MakeBoxKP(); err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// BrowserServerFactory returns a ServerFactory for SocketServer serving all
+// clients from the same backend.
+func BrowserServerFactory(backend BrowserBackend) ServerFactory {
+	return func() (ServerI, error) {
+		return NewBrowserServer(backend)
+	}
+}
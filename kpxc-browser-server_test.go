@@ -0,0 +1,192 @@
+package keepassxc_browser
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// serverConnection hands the requests of a Client straight to a ServerI.
+type serverConnection struct {
+	server ServerI
+	res    [][]byte
+}
+
+func (conn *serverConnection) Connect(address string) error { return nil }
+func (conn *serverConnection) Close()                       {}
+
+func (conn *serverConnection) Send(message []byte) (err error) {
+	res, err := conn.server.HandleReq(message)
+	if err != nil {
+		return err
+	}
+	conn.res = append(conn.res, res)
+	return nil
+}
+
+func (conn *serverConnection) Recv(bufsize int, timeout int) (ret []byte, err error) {
+	if len(conn.res) == 0 {
+		return nil, fmt.Errorf("No response")
+	}
+	ret, conn.res = conn.res[0], conn.res[1:]
+	return ret, nil
+}
+
+// stubBackend accepts one association and answers everything else.
+type stubBackend struct {
+	idKey string
+}
+
+func (b *stubBackend) GetDatabasehash(clientId string, req *MsgGetDatabasehash) (*MsgGetDatabasehash, error) {
+	return &MsgGetDatabasehash{MsgBase{Hash: "stubhash"}}, nil
+}
+
+func (b *stubBackend) Associate(clientId string, req *MsgAssociate) (*MsgAssociate, error) {
+	b.idKey = req.IdKey
+	return &MsgAssociate{MsgBase: MsgBase{Id: "stub", Hash: "stubhash"}}, nil
+}
+
+func (b *stubBackend) TestAssociate(clientId string, req *MsgAssociate) (*MsgAssociate, error) {
+	if req.Id != "stub" || req.Key == "" || req.Key != b.idKey {
+		return nil, NewProtocolError(ErrCodeAssociationFailed, "Association failed")
+	}
+	return &MsgAssociate{MsgBase: MsgBase{Id: "stub", Hash: "stubhash"}}, nil
+}
+
+func (b *stubBackend) GeneratePassword(clientId string, req *MsgGeneratePassword) (*MsgGeneratePassword, error) {
+	return &MsgGeneratePassword{Password: Secret("generated")}, nil
+}
+
+func (b *stubBackend) GetLogins(clientId string, req *MsgGetLogins) (*MsgGetLogins, error) {
+	return &MsgGetLogins{Entries: []LoginEntry{{Login: "bob", Password: Secret("secret"), Uuid: "u1"}}}, nil
+}
+
+func (b *stubBackend) SetLogin(clientId string, req *MsgSetLogin) (*MsgSetLogin, error) {
+	return &MsgSetLogin{}, nil
+}
+
+func (b *stubBackend) LockDatabase(clientId string, req *MsgLockDatabase) (*MsgLockDatabase, error) {
+	return &MsgLockDatabase{}, nil
+}
+
+func (b *stubBackend) GetDatabaseGroups(clientId string, req *MsgGetDatabaseGroups) (*MsgGetDatabaseGroups, error) {
+	return &MsgGetDatabaseGroups{}, nil
+}
+
+func (b *stubBackend) CreateNewGroup(clientId string, req *MsgCreateNewGroup) (*MsgCreateNewGroup, error) {
+	return &MsgCreateNewGroup{Name: req.GroupName, Uuid: "g1"}, nil
+}
+
+func (b *stubBackend) GetTotp(clientId string, req *MsgGetTotp) (*MsgGetTotp, error) {
+	return &MsgGetTotp{Totp: Secret("123456"), Uuid: req.Uuid}, nil
+}
+
+func newStubClient(t *testing.T, backend BrowserBackend) *Client {
+	t.Helper()
+	server, err := NewBrowserServer(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClient("test", WithConnection(&serverConnection{server: server}), WithAddress("stub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.ChangePublicKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+// associatedActions calls every action needing an association.
+var associatedActions = map[string]func(c *Client) error{
+	"get-logins": func(c *Client) (err error) {
+		_, err = c.GetLogins("https://example.com", "", "")
+		return err
+	},
+	"set-login": func(c *Client) (err error) {
+		_, err = c.SetLogin("https://example.com", "", "bob", Secret("new"), "", "", "u1")
+		return err
+	},
+	"get-totp": func(c *Client) (err error) {
+		_, err = c.GetTotp("u1")
+		return err
+	},
+	"get-database-groups": func(c *Client) (err error) {
+		_, err = c.GetDatabaseGroups()
+		return err
+	},
+	"create-new-group": func(c *Client) (err error) {
+		_, err = c.CreateNewGroup("Evil")
+		return err
+	},
+	"lock-database": func(c *Client) error {
+		return c.LockDatabase()
+	},
+}
+
+func TestBrowserServerRequiresAssociation(t *testing.T) {
+	backend := &stubBackend{}
+	c := newStubClient(t, backend)
+
+	// allowed before associating
+	if _, err := c.GetDatabasehash(); err != nil {
+		t.Fatalf("get-databasehash: %v", err)
+	}
+	if _, err := c.GeneratePassword(0); err != nil {
+		t.Fatalf("generate-password: %v", err)
+	}
+	for action, fn := range associatedActions {
+		var perr *ProtocolError
+		if err := fn(c); !errors.As(err, &perr) || perr.Code != ErrCodeAssociationFailed {
+			t.Errorf("%s before associating: got %v, want association failed", action, err)
+		}
+	}
+
+	// a failed test-associate does not unlock them
+	c.AId, c.IdKey = "stub", "wrong"
+	if _, err := c.TestAssociate(); err == nil {
+		t.Fatal("test-associate with a wrong key succeeded")
+	}
+	if err := associatedActions["get-totp"](c); err == nil {
+		t.Error("get-totp succeeded after a failed test-associate")
+	}
+
+	if _, err := c.Associate(); err != nil {
+		t.Fatal(err)
+	}
+	for action, fn := range associatedActions {
+		if err := fn(c); err != nil {
+			t.Errorf("%s after associating: %v", action, err)
+		}
+	}
+}
+
+func TestBrowserServerTestAssociate(t *testing.T) {
+	backend := &stubBackend{}
+	c := newStubClient(t, backend)
+	if _, err := c.Associate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new connection has to prove its association again
+	c2 := newStubClient(t, backend)
+	if err := associatedActions["get-logins"](c2); err == nil {
+		t.Fatal("get-logins succeeded on a new connection")
+	}
+	c2.AId, c2.IdKey = c.AId, c.IdKey
+	if _, err := c2.TestAssociate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := associatedActions["get-logins"](c2); err != nil {
+		t.Errorf("get-logins after test-associate: %v", err)
+	}
+
+	// and so has a new key exchange
+	if _, err := c2.ChangePublicKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if err := associatedActions["get-logins"](c2); err == nil {
+		t.Error("get-logins succeeded after a new key exchange")
+	}
+}
@@ -0,0 +1,70 @@
+package keepassxc_browser
+
+import "fmt"
+
+type ClientOption func(*Client) error
+
+// WithConnection replaces the default PosixConnection. Socket discovery is
+// skipped unless an address is given with WithAddress as well.
+func WithConnection(conn ConnectionI) ClientOption {
+	return func(c *Client) error {
+		if conn == nil {
+			return fmt.Errorf("Connection must not be nil")
+		}
+		c.conn = conn
+		return nil
+	}
+}
+
+func WithAddress(address string) ClientOption {
+	return func(c *Client) error {
+		c.serverAddress = address
+		return nil
+	}
+}
+
+// WithKeyPair uses an existing session key pair instead of a fresh one. The
+// client keeps a copy, Close only wipes that.
+func WithKeyPair(keyPair BoxKP) ClientOption {
+	return func(c *Client) error {
+		if len(keyPair.PublicKey.Bytes) != BoxKeySize || len(keyPair.SecretKey.Bytes) != BoxKeySize {
+			return fmt.Errorf("Invalid key pair")
+		}
+		c.keyPair.PublicKey.Bytes = append([]byte(nil), keyPair.PublicKey.Bytes...)
+		c.keyPair.SecretKey.Bytes = append([]byte(nil), keyPair.SecretKey.Bytes...)
+		return nil
+	}
+}
+
+func WithLogger(logger LoggerI) ClientOption {
+	return func(c *Client) error {
+		if logger == nil {
+			logger = nopLogger{}
+		}
+		c.logger = logger
+		return nil
+	}
+}
+
+// WithTimeout sets the default receive timeout in seconds, 0 waits forever.
+func WithTimeout(timeout int) ClientOption {
+	return func(c *Client) error {
+		if timeout < 0 {
+			return fmt.Errorf("Invalid timeout: %d", timeout)
+		}
+		c.timeout = timeout
+		return nil
+	}
+}
+
+// WithRequestIDGenerator replaces RandomRequestID, e.g. with
+// SequentialRequestID for deterministic IDs in tests.
+func WithRequestIDGenerator(gen RequestIDGeneratorFunc) ClientOption {
+	return func(c *Client) error {
+		if gen == nil {
+			return fmt.Errorf("Request ID generator must not be nil")
+		}
+		c.requestID = gen
+		return nil
+	}
+}
@@ -0,0 +1,107 @@
+package keepassxc_browser
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWithKeyPair(t *testing.T) {
+	keyPair, err := MakeBoxKP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := append([]byte(nil), keyPair.SecretKey.Bytes...)
+
+	c, err := NewClient("test", WithKeyPair(keyPair), WithConnection(&serverConnection{}), WithAddress("stub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(c.keyPair.PublicKey.Bytes, keyPair.PublicKey.Bytes) {
+		t.Error("key pair not used")
+	}
+	c.Close()
+	if !bytes.Equal(keyPair.SecretKey.Bytes, secret) {
+		t.Error("Close wiped the key pair of the caller")
+	}
+
+	keyPair.SecretKey.Bytes = keyPair.SecretKey.Bytes[:8]
+	if _, err = NewClient("test", WithKeyPair(keyPair), WithAddress("stub")); err == nil {
+		t.Error("short secret key accepted")
+	}
+}
+
+func TestWithConnection(t *testing.T) {
+	if _, err := NewClient("test", WithConnection(nil)); err == nil {
+		t.Error("nil connection accepted")
+	}
+
+	server, err := NewBrowserServer(&stubBackend{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := &serverConnection{server: server}
+	c, err := NewClient("test", WithConnection(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// no socket is resolved for an injected connection
+	if c.serverAddress != "" {
+		t.Errorf("got address %s", c.serverAddress)
+	}
+	if err = c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.ChangePublicKeys(); err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.GetDatabasehash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Hash != "stubhash" {
+		t.Errorf("got hash %s, want stubhash", res.Hash)
+	}
+}
+
+func TestWithRequestIDGenerator(t *testing.T) {
+	server, err := NewBrowserServer(&stubBackend{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClient("test", WithRequestIDGenerator(SequentialRequestID("test")),
+		WithConnection(&serverConnection{server: server}), WithAddress("stub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.ChangePublicKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RequestId != "test-1" {
+		t.Errorf("got request ID %q, want test-1", res.RequestId)
+	}
+	req, err := c.newReq("get-databasehash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.RequestId != "test-2" {
+		t.Errorf("got request ID %q, want test-2", req.RequestId)
+	}
+
+	// other clients keep random IDs
+	c2, err := NewClient("test", WithConnection(&serverConnection{server: server}), WithAddress("stub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req, err = c2.newReq("get-databasehash"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.HasPrefix(req.RequestId, "test-") {
+		t.Errorf("generator shared between clients: %q", req.RequestId)
+	}
+
+	if _, err = NewClient("test", WithRequestIDGenerator(nil), WithAddress("stub")); err == nil {
+		t.Error("nil generator accepted")
+	}
+}
@@ -7,24 +7,33 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"path"
-	"runtime"
 	"strconv"
-
-	"github.com/jamesruan/sodium"
 )
 
 type Client struct {
 	ClientId      string
 	IdKey         string
 	AId           string
-	keyPair       sodium.BoxKP
-	serverPubKey  sodium.BoxPublicKey
+	Associations  map[string]Association `json:",omitempty"`
+	NextIdKey     string                 `json:",omitempty"`
+	keyPair       BoxKP
+	serverPubKey  BoxPublicKey
 	serverAddress string
 	conn          ConnectionI
+	logger        LoggerI
+	timeout       int
+	requestID     RequestIDGeneratorFunc
+}
+
+func (c *Client) newReq(action string) (ret *ConnMsg, err error) {
+	return newConnReq(action, c.ClientId, c.requestID)
 }
 
 func (c *Client) sendMsg(req *ConnMsg, timeout int) (ret *ConnMsg, err error) {
+	if timeout == 0 {
+		timeout = c.timeout
+	}
+
 	if err = c.prepareMsg(req); err != nil {
 		return nil, err
 	}
@@ -34,8 +43,7 @@ func (c *Client) sendMsg(req *ConnMsg, timeout int) (ret *ConnMsg, err error) {
 		return nil, err
 	}
 
-	//slog.LOG_DEBUGF("SendReq jreq: %v\n", jreq)
-	//slog.LOG_DEBUGF("SendReq jreq: %s\n", jreq)
+	c.logger.Printf("Send request: action=%s requestID=%s\n", req.ActionName, req.RequestId)
 	if err = c.conn.Send(jreq); err != nil {
 		return nil, err
 	}
@@ -44,8 +52,7 @@ func (c *Client) sendMsg(req *ConnMsg, timeout int) (ret *ConnMsg, err error) {
 	if err != nil {
 		return nil, err
 	}
-	//slog.LOG_DEBUGF("SendReq jres: %v\n", jres)
-	//slog.LOG_DEBUGF("SendReq jres: %s\n", jres)
+	c.logger.Printf("Received response: %d bytes\n", len(jres))
 
 	// stupid protocol....
 	if len(jres) == 2 {
@@ -90,7 +97,11 @@ func (c *Client) prepareMsg(req *ConnMsg) (err error) {
 	if err != nil {
 		return err
 	}
-	jedata := EncryptBytes(req.nonce, c.serverPubKey, c.keyPair.SecretKey, jdata)
+	jedata, err := EncryptBytes(req.nonce, c.serverPubKey, c.keyPair.SecretKey, jdata)
+	wipeBytes(jdata)
+	if err != nil {
+		return err
+	}
 	req.Message = base64.StdEncoding.EncodeToString(jedata)
 
 	return nil
@@ -102,14 +113,14 @@ func (c *Client) postMsg(req, res *ConnMsg) (err error) {
 		if err != nil {
 			ec = -1
 		}
-		return fmt.Errorf("Error: %s (Code: %d)", res.Error, ec)
+		return NewProtocolError(ec, res.Error)
 	}
 	if res.Success != "" && res.Success != "true" {
 		return fmt.Errorf("Unknown Error")
 	}
 
-	cnonce := sodium.BoxNonce{}
-	cnonce.Bytes = sodium.Bytes(req.nonce.Bytes)
+	cnonce := BoxNonce{}
+	cnonce.Bytes = append([]byte(nil), req.nonce.Bytes...)
 	cnonce.Next()
 
 	if res.Nonce != "" && bytes.Compare(cnonce.Bytes, res.nonce.Bytes) != 0 {
@@ -125,7 +136,12 @@ func (c *Client) postMsg(req, res *ConnMsg) (err error) {
 		if err != nil {
 			return err
 		}
-		if err = json.Unmarshal(jdata, res.data); err != nil {
+		// decode straight from the decrypted buffer, secrets are copied
+		// into their Secret fields only, then wipe the plaintext
+		err = json.Unmarshal(jdata, res.data)
+		wipeBytes(jdata)
+		if err != nil {
+			res.data.Wipe()
 			return err
 		}
 		if !res.data.IsSuccess() {
@@ -140,12 +156,16 @@ func (c *Client) Connect() error {
 	return c.conn.Connect(c.serverAddress)
 }
 
+// Close closes the connection and wipes the session secret key. The client
+// has to be recreated afterwards.
 func (c *Client) Close() {
 	c.conn.Close()
+	wipeBytes(c.keyPair.SecretKey.Bytes)
+	wipeBytes(c.serverPubKey.Bytes)
 }
 
 func (c *Client) ChangePublicKeys() (ret *ConnMsg, err error) {
-	req, err := GenerateConnReq("change-public-keys", c.ClientId)
+	req, err := c.newReq("change-public-keys")
 	if err != nil {
 		return nil, err
 	}
@@ -163,7 +183,7 @@ func (c *Client) ChangePublicKeys() (ret *ConnMsg, err error) {
 }
 
 func (c *Client) GetDatabasehash() (ret *MsgGetDatabasehash, err error) {
-	req, err := GenerateConnReq("get-databasehash", c.ClientId)
+	req, err := c.newReq("get-databasehash")
 	if err != nil {
 		return nil, err
 	}
@@ -178,7 +198,7 @@ func (c *Client) GetDatabasehash() (ret *MsgGetDatabasehash, err error) {
 }
 
 func (c *Client) Associate() (ret *MsgAssociate, err error) {
-	req, err := GenerateConnReq("associate", c.ClientId)
+	req, err := c.newReq("associate")
 	if err != nil {
 		return nil, err
 	}
@@ -191,12 +211,13 @@ func (c *Client) Associate() (ret *MsgAssociate, err error) {
 	}
 	ret = res.data.(*MsgAssociate)
 	c.AId = ret.Id
+	c.storeAssoc(ret.Hash, Association{Id: c.AId, IdKey: c.IdKey})
 
 	return ret, nil
 }
 
 func (c *Client) TestAssociate() (ret *MsgAssociate, err error) {
-	req, err := GenerateConnReq("test-associate", c.ClientId)
+	req, err := c.newReq("test-associate")
 	if err != nil {
 		return nil, err
 	}
@@ -213,7 +234,7 @@ func (c *Client) TestAssociate() (ret *MsgAssociate, err error) {
 }
 
 func (c *Client) GeneratePassword(timeout int) (ret *MsgGeneratePassword, err error) {
-	req, err := GenerateConnReq("generate-password", c.ClientId)
+	req, err := c.newReq("generate-password")
 	if err != nil {
 		return nil, err
 	}
@@ -228,7 +249,7 @@ func (c *Client) GeneratePassword(timeout int) (ret *MsgGeneratePassword, err er
 }
 
 func (c *Client) GetLogins(url, submitUrl, httpAuth string) (ret *MsgGetLogins, err error) {
-	req, err := GenerateConnReq("get-logins", c.ClientId)
+	req, err := c.newReq("get-logins")
 	if err != nil {
 		return nil, err
 	}
@@ -236,12 +257,7 @@ func (c *Client) GetLogins(url, submitUrl, httpAuth string) (ret *MsgGetLogins,
 	reqi.Url = url
 	reqi.SubmitUrl = submitUrl
 	reqi.HttpAuth = httpAuth
-	reqi.Keys = []key{
-		{
-			Id:  c.AId,
-			Key: c.IdKey,
-		},
-	}
+	reqi.Keys = c.keys()
 
 	res, err := c.SendMsg(req)
 	if err != nil {
@@ -252,8 +268,8 @@ func (c *Client) GetLogins(url, submitUrl, httpAuth string) (ret *MsgGetLogins,
 	return ret, nil
 }
 
-func (c *Client) SetLogin(url, submitUrl, login, password, group, groupUuid, uuid string) (ret *MsgSetLogin, err error) {
-	req, err := GenerateConnReq("set-login", c.ClientId)
+func (c *Client) SetLogin(url, submitUrl, login string, password Secret, group, groupUuid, uuid string) (ret *MsgSetLogin, err error) {
+	req, err := c.newReq("set-login")
 	if err != nil {
 		return nil, err
 	}
@@ -276,7 +292,7 @@ func (c *Client) SetLogin(url, submitUrl, login, password, group, groupUuid, uui
 }
 
 func (c *Client) LockDatabase() (err error) {
-	req, err := GenerateConnReq("lock-database", c.ClientId)
+	req, err := c.newReq("lock-database")
 	if err != nil {
 		return err
 	}
@@ -286,7 +302,7 @@ func (c *Client) LockDatabase() (err error) {
 }
 
 func (c *Client) GetDatabaseGroups() (ret *MsgGetDatabaseGroups, err error) {
-	req, err := GenerateConnReq("get-database-groups", c.ClientId)
+	req, err := c.newReq("get-database-groups")
 	if err != nil {
 		return nil, err
 	}
@@ -301,7 +317,7 @@ func (c *Client) GetDatabaseGroups() (ret *MsgGetDatabaseGroups, err error) {
 }
 
 func (c *Client) CreateNewGroup(groupName string) (ret *MsgCreateNewGroup, err error) {
-	req, err := GenerateConnReq("create-new-group", c.ClientId)
+	req, err := c.newReq("create-new-group")
 	if err != nil {
 		return nil, err
 	}
@@ -317,7 +333,7 @@ func (c *Client) CreateNewGroup(groupName string) (ret *MsgCreateNewGroup, err e
 }
 
 func (c *Client) GetTotp(uuid string) (ret *MsgGetTotp, err error) {
-	req, err := GenerateConnReq("get-totp", c.ClientId)
+	req, err := c.newReq("get-totp")
 	if err != nil {
 		return nil, err
 	}
@@ -332,12 +348,42 @@ func (c *Client) GetTotp(uuid string) (ret *MsgGetTotp, err error) {
 	return ret, nil
 }
 
+func (c *Client) DeleteEntry(uuid string) (ret *MsgDeleteEntry, err error) {
+	req, err := c.newReq("delete-entry")
+	if err != nil {
+		return nil, err
+	}
+	req.data.(*MsgDeleteEntry).Uuid = uuid
+
+	res, err := c.SendMsg(req)
+	if err != nil {
+		return nil, err
+	}
+	ret = res.data.(*MsgDeleteEntry)
+
+	return ret, nil
+}
+
+// SaveAssoc writes the association to file readable by the owner only, it
+// holds the identity key. Existing files are restricted as well.
 func (c *Client) SaveAssoc(file string) (err error) {
 	jassoc, err := json.Marshal(c)
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(file, jassoc, 0644)
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err = f.Chmod(0600); err == nil {
+		_, err = f.Write(jassoc)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
 }
 
 func (c *Client) LoadAssoc(file string) (err error) {
@@ -352,37 +398,79 @@ func (c *Client) LoadAssoc(file string) (err error) {
 	return err
 }
 
-func NewClient(clientId string) (client *Client, err error) {
+func NewClient(clientId string, opts ...ClientOption) (client *Client, err error) {
 	client = new(Client)
-
 	client.ClientId = clientId
-	client.IdKey = base64.StdEncoding.EncodeToString(sodium.MakeBoxKP().PublicKey.Bytes)
-	client.keyPair = sodium.MakeBoxKP()
+	client.logger = nopLogger{}
+	client.requestID = RandomRequestID
 
-	tmpDir := os.Getenv("TMPDIR")
-	if tmpDir != "" {
-		tmpDir = path.Join(tmpDir, SocketName)
+	for _, opt := range opts {
+		if err = opt(client); err != nil {
+			return nil, err
+		}
 	}
 
-	xdgRuntimeDir := os.Getenv(("XDG_RUNTIME_DIR"))
-	if xdgRuntimeDir != "" {
-		xdgRuntimeDir = path.Join(xdgRuntimeDir, SocketName)
+	if client.IdKey, err = generateIdKey(); err != nil {
+		return nil, err
+	}
+	if client.keyPair.SecretKey.Bytes == nil {
+		if client.keyPair, err = MakeBoxKP(); err != nil {
+			return nil, err
+		}
 	}
 
-	oss := runtime.GOOS
-	switch oss {
-	case "linux":
+	if client.conn != nil && client.serverAddress == "" {
+		return client, nil
+	}
+	if client.conn == nil {
 		client.conn = &PosixConnection{}
-		if _, err = os.Stat(tmpDir); err == nil {
-			client.serverAddress = tmpDir
-			return client, err
+	}
+	if client.serverAddress, err = ResolveSocket(client.serverAddress); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// OpenClient creates a client with the identity stored in assocFile, if it
+// exists, connects it, exchanges the keys and selects the association of
+// the active database. It returns the hash of that database. The selected
+// association is verified with test-associate, KeePassXC only answers the
+// requests needing one afterwards. An association KeePassXC no longer
+// knows is dropped, so the database counts as not associated, other errors
+// are returned.
+func OpenClient(clientId, assocFile string, opts ...ClientOption) (ret *Client, hash string, err error) {
+	if ret, err = NewClient(clientId, opts...); err != nil {
+		return nil, "", err
+	}
+	if assocFile != "" {
+		if err = ret.LoadAssoc(assocFile); err != nil && !os.IsNotExist(err) {
+			return nil, "", err
 		}
-		if _, err := os.Stat(xdgRuntimeDir); err == nil {
-			client.serverAddress = xdgRuntimeDir
-			return client, err
+	}
+
+	if err = ret.Connect(); err != nil {
+		return nil, "", err
+	}
+	if _, err = ret.ChangePublicKeys(); err != nil {
+		ret.Close()
+		return nil, "", err
+	}
+	dbhash, err := ret.GetDatabasehash()
+	if err != nil {
+		ret.Close()
+		return nil, "", err
+	}
+	if ret.UseAssoc(dbhash.Hash) {
+		if _, err = ret.TestAssociate(); err != nil {
+			perr, ok := err.(*ProtocolError)
+			if !ok || (perr.Code != ErrCodeAssociationFailed && perr.Code != ErrCodeEncryptionKeyUnrecognized) {
+				ret.Close()
+				return nil, "", err
+			}
+			ret.forgetAssoc(dbhash.Hash)
 		}
-		return nil, fmt.Errorf("Unable to locate keepassxc socket")
-	default:
-		return nil, fmt.Errorf("Operating System: '%s' not supported", oss)
 	}
+
+	return ret, dbhash.Hash, nil
 }
@@ -0,0 +1,94 @@
+package keepassxc_browser
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveAssocMode(t *testing.T) {
+	c, err := NewClient("test", WithAddress("stub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "assoc.json")
+	// left readable by an older version
+	if err = os.WriteFile(file, []byte(`{"clientId":"old","idKey":"old"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.SaveAssoc(file); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := fi.Mode().Perm(); mode != 0600 {
+		t.Errorf("mode %o, want 600", mode)
+	}
+
+	c2, _ := NewClient("", WithAddress("stub"))
+	if err = c2.LoadAssoc(file); err != nil {
+		t.Fatal(err)
+	}
+	if c2.IdKey != c.IdKey {
+		t.Error("identity key not saved")
+	}
+}
+
+// lockedBackend answers test-associate like a locked database.
+type lockedBackend struct {
+	stubBackend
+}
+
+func (b *lockedBackend) TestAssociate(clientId string, req *MsgAssociate) (*MsgAssociate, error) {
+	return nil, NewProtocolError(ErrCodeDatabaseNotOpened, "Database not opened")
+}
+
+func TestOpenClientStaleAssoc(t *testing.T) {
+	backend := &stubBackend{}
+	c := newStubClient(t, backend)
+	if _, err := c.Associate(); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "assoc.json")
+	if err := c.SaveAssoc(file); err != nil {
+		t.Fatal(err)
+	}
+
+	open := func(backend BrowserBackend) (*Client, error) {
+		server, err := NewBrowserServer(backend)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, _, err := OpenClient("test", file, WithConnection(&serverConnection{server: server}), WithAddress("stub"))
+		return c, err
+	}
+
+	c2, err := open(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2.AId != "stub" {
+		t.Errorf("association not selected: %q", c2.AId)
+	}
+	if err = associatedActions["get-logins"](c2); err != nil {
+		t.Errorf("get-logins: %v", err)
+	}
+
+	// KeePassXC forgot the association, e.g. it was removed there
+	backend.idKey = "other"
+	c3, err := open(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c3.Associations["stubhash"]; ok || c3.AId != "" || c3.IdKey == "" {
+		t.Errorf("stale association kept: %+v", c3.Associations)
+	}
+
+	var perr *ProtocolError
+	if _, err = open(&lockedBackend{stubBackend{idKey: c.IdKey}}); !errors.As(err, &perr) || perr.Code != ErrCodeDatabaseNotOpened {
+		t.Errorf("got %v, want database not opened", err)
+	}
+}
@@ -1,16 +1,21 @@
 package keepassxc_browser
 
 import (
+	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
-	"math/rand"
-	"time"
-
-	"github.com/jamesruan/sodium"
+	"fmt"
+	"sync"
 )
 
 const SocketName string = "org.keepassxc.KeePassXC.BrowserServer"
 const BufSize int = 1024 * 1024
+const RequestIDSize int = 16
+
+// RequestIDGeneratorFunc makes the request IDs of a client, see
+// WithRequestIDGenerator.
+type RequestIDGeneratorFunc func() (string, error)
 
 type ConnectionI interface {
 	Connect(string) error
@@ -31,13 +36,26 @@ type ConnMsg struct {
 	ErrorCode  string `json:"errorCode"`
 	Version    string `json:"version"`
 	data       MsgI
-	nonce      sodium.BoxNonce
+	nonce      BoxNonce
+	req        *ConnMsg
 }
 
 func (c *ConnMsg) GetData() interface{} {
 	return c.data
 }
 
+// Request returns the request a response answers, if known. Intermediaries
+// like KpXcMitm set it before handing responses to their modifiers.
+func (c *ConnMsg) Request() *ConnMsg {
+	return c.req
+}
+
+func (c *ConnMsg) Wipe() {
+	if c.data != nil {
+		c.data.Wipe()
+	}
+}
+
 func ParseConnMsg(bmsg []byte) (ret *ConnMsg, err error) {
 	ret = new(ConnMsg)
 	if err = json.Unmarshal(bmsg, ret); err != nil {
@@ -58,41 +76,90 @@ func ParseConnMsg(bmsg []byte) (ret *ConnMsg, err error) {
 	return ret, err
 }
 
-func GenerateRequestID() string {
-	v := make([]byte, 8)
-	rand.Seed(time.Now().UnixNano())
-
-	for i := 0; i < 8; i++ {
-		v[i] = byte(rand.Intn(255-0) + 0)
+// RandomRequestID returns RequestIDSize bytes from crypto/rand, hex encoded.
+func RandomRequestID() (ret string, err error) {
+	v := make([]byte, RequestIDSize)
+	if _, err = rand.Read(v); err != nil {
+		return "", err
 	}
-	return string(v)
-}
 
-func EncryptBytes(nonce sodium.BoxNonce, pubkey sodium.BoxPublicKey, privkey sodium.BoxSecretKey, data []byte) (ret []byte) {
-	sdata := sodium.Bytes(data)
-	ret = sdata.Box(nonce, pubkey, privkey)
-
-	return ret
+	return hex.EncodeToString(v), nil
 }
 
-func DecryptBytes(nonce sodium.BoxNonce, pubkey sodium.BoxPublicKey, privkey sodium.BoxSecretKey, data []byte) (ret []byte, err error) {
-	sdata := sodium.Bytes(data)
+// SequentialRequestID returns a deterministic generator yielding
+// prefix-1, prefix-2, ... which is meant for tests and debugging.
+func SequentialRequestID(prefix string) RequestIDGeneratorFunc {
+	var mu sync.Mutex
+	var n uint64
+
+	return func() (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		n++
+		return fmt.Sprintf("%s-%d", prefix, n), nil
+	}
+}
 
-	return sdata.BoxOpen(nonce, pubkey, privkey)
+// GenerateRequestID returns a random request ID, see RandomRequestID.
+func GenerateRequestID() (ret string, err error) {
+	return RandomRequestID()
 }
 
 func GenerateConnReq(action, clientID string) (ret *ConnMsg, err error) {
+	return newConnReq(action, clientID, RandomRequestID)
+}
+
+func newConnReq(action, clientID string, requestID RequestIDGeneratorFunc) (ret *ConnMsg, err error) {
 	ret = new(ConnMsg)
 
 	if ret.data, err = GetMessageType(action); err != nil {
 		return nil, err
 	}
 
-	sodium.Randomize(&ret.nonce)
+	if ret.nonce, err = RandomNonce(); err != nil {
+		return nil, err
+	}
 	ret.Nonce = base64.StdEncoding.EncodeToString(ret.nonce.Bytes)
-	ret.RequestId = GenerateRequestID()
+	if ret.RequestId, err = requestID(); err != nil {
+		return nil, err
+	}
 	ret.ClientId = clientID
 	ret.ActionName = action
 
 	return ret, nil
 }
+
+// encryptedReply builds the response to req carrying data, encrypted with
+// keyPair for peerKey the way KeePassXC answers.
+func encryptedReply(req *ConnMsg, data MsgI, keyPair BoxKP, peerKey BoxPublicKey) (ret []byte, err error) {
+	nonce := BoxNonce{Bytes: append([]byte(nil), req.nonce.Bytes...)}
+	nonce.Next()
+	snonce := base64.StdEncoding.EncodeToString(nonce.Bytes)
+
+	if b, ok := data.(interface{ base() *MsgBase }); ok {
+		mb := b.base()
+		mb.ActionName = req.ActionName
+		mb.Version = ServerVersion
+		mb.Success = "true"
+		mb.Nonce = snonce
+	}
+
+	jdata, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	jedata, err := EncryptBytes(nonce, peerKey, keyPair.SecretKey, jdata)
+	wipeBytes(jdata)
+	if err != nil {
+		return ErrorReply(req, NewProtocolError(ErrCodeCannotEncryptMessage, "Cannot encrypt message"))
+	}
+
+	res := &ConnMsg{
+		ActionName: req.ActionName,
+		RequestId:  req.RequestId,
+		Nonce:      snonce,
+		Message:    base64.StdEncoding.EncodeToString(jedata),
+	}
+
+	return json.Marshal(res)
+}
@@ -0,0 +1,33 @@
+package keepassxc_browser
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestRandomRequestID(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		id, err := RandomRequestID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := hex.DecodeString(id)
+		if err != nil || len(b) != RequestIDSize {
+			t.Fatalf("%q is no hex encoded %d byte ID", id, RequestIDSize)
+		}
+		if seen[id] {
+			t.Fatalf("duplicate request ID %s", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestSequentialRequestID(t *testing.T) {
+	gen := SequentialRequestID("req")
+	for _, want := range []string{"req-1", "req-2", "req-3"} {
+		if id, err := gen(); err != nil || id != want {
+			t.Errorf("got %q, %v, want %s", id, err, want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package keepassxc_browser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// CredentialGroup keeps the credentials of a helper, e.g. for git or
+// docker, in one group of the database. KeePassXC returns the matching
+// entries of every group, only the ones of the group are handed out.
+type CredentialGroup struct {
+	client *Client
+	path   string
+	uuid   string
+	// no other group has the name, see contains
+	unique bool
+}
+
+// FindGroupPath returns the uuid of the group at path below groups, path
+// holds the group names without the root group.
+func FindGroupPath(groups []GroupChild, path []string) (uuid string, ok bool) {
+	for _, g := range groups {
+		if g.Name != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return g.Uuid, true
+		}
+		return FindGroupPath(g.Children, path[1:])
+	}
+
+	return "", false
+}
+
+func countGroups(groups []GroupChild, name string) (ret int) {
+	for _, g := range groups {
+		if g.Name == name {
+			ret++
+		}
+		ret += countGroups(g.Children, name)
+	}
+
+	return ret
+}
+
+// WipeEntries wipes the passwords of entries.
+func WipeEntries(entries []LoginEntry) {
+	for i := range entries {
+		entries[i].Wipe()
+	}
+}
+
+func (g *CredentialGroup) Path() string {
+	return g.path
+}
+
+// Uuid is empty until Open found or created the group.
+func (g *CredentialGroup) Uuid() string {
+	return g.uuid
+}
+
+func (g *CredentialGroup) name() string {
+	return g.path[strings.LastIndex(g.path, "/")+1:]
+}
+
+// Open looks the group up, creating it if create is set. Without the group
+// there are no credentials, found reports whether it exists.
+func (g *CredentialGroup) Open(create bool) (found bool, err error) {
+	res, err := g.client.GetDatabaseGroups()
+	if err != nil {
+		return false, err
+	}
+	// paths are relative to the root group, which is not part of them
+	var children []GroupChild
+	for _, root := range res.Groups.Groups {
+		children = append(children, root.Children...)
+	}
+	n := countGroups(res.Groups.Groups, g.name())
+	if uuid, ok := FindGroupPath(children, strings.Split(g.path, "/")); ok {
+		g.uuid = uuid
+		g.unique = n == 1
+		return true, nil
+	}
+	if !create {
+		return false, nil
+	}
+
+	c, err := g.client.CreateNewGroup(g.path)
+	if err != nil {
+		return false, err
+	}
+	g.uuid = c.Uuid
+	g.unique = n == 0
+
+	return true, nil
+}
+
+// contains reports whether e is in the group. KeePassXC versions without
+// groupUuid only report the group name, which is used if no other group
+// has it.
+func (g *CredentialGroup) contains(e *LoginEntry) bool {
+	if g.uuid == "" {
+		return false
+	}
+	if e.GroupUuid != "" {
+		return e.GroupUuid == g.uuid
+	}
+
+	return g.unique && e.Group == g.name()
+}
+
+// Logins returns the entries of the group matching url, none is not an
+// error. The entries of other groups are wiped.
+func (g *CredentialGroup) Logins(url string) (ret []LoginEntry, err error) {
+	res, err := g.client.GetLogins(url, "", "")
+	if err != nil {
+		var perr *ProtocolError
+		if errors.As(err, &perr) && perr.Code == ErrCodeNoLoginsFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	for i := range res.Entries {
+		if g.contains(&res.Entries[i]) {
+			ret = append(ret, res.Entries[i])
+			continue
+		}
+		res.Entries[i].Wipe()
+	}
+
+	return ret, nil
+}
+
+// SetLogin stores login for url in the group, uuid names the entry to
+// update and is empty for a new one.
+func (g *CredentialGroup) SetLogin(url, login string, password Secret, uuid string) (err error) {
+	if g.uuid == "" {
+		return fmt.Errorf("Group not opened: %s", g.path)
+	}
+	_, err = g.client.SetLogin(url, "", login, password, g.path, g.uuid, uuid)
+
+	return err
+}
+
+// NewCredentialGroup uses the group at path, e.g. "Dev/Git", leading and
+// trailing slashes are ignored. Call Open before using it.
+func NewCredentialGroup(client *Client, path string) (ret *CredentialGroup, err error) {
+	if client == nil {
+		return nil, fmt.Errorf("Client required")
+	}
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil, fmt.Errorf("Group path required")
+	}
+
+	ret = new(CredentialGroup)
+	ret.client = client
+	ret.path = path
+
+	return ret, nil
+}
@@ -0,0 +1,98 @@
+package keepassxc_browser
+
+import (
+	"testing"
+)
+
+// nameOnlyBackend answers like KeePassXC versions reporting only the group
+// name of an entry.
+type nameOnlyBackend struct {
+	*KdbxBackend
+}
+
+func (b nameOnlyBackend) GetLogins(clientId string, req *MsgGetLogins) (ret *MsgGetLogins, err error) {
+	if ret, err = b.KdbxBackend.GetLogins(clientId, req); err != nil {
+		return nil, err
+	}
+	for i := range ret.Entries {
+		ret.Entries[i].GroupUuid = ""
+	}
+
+	return ret, nil
+}
+
+func groupLogins(t *testing.T, g *CredentialGroup, url string) (ret []string) {
+	t.Helper()
+	entries, err := g.Logins(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		ret = append(ret, e.Login)
+	}
+
+	return ret
+}
+
+func TestCredentialGroup(t *testing.T) {
+	c, backend := newKdbxClient(t)
+	other, err := c.CreateNewGroup("Work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.SetLogin("https://example.com", "", "other", Secret("pw"), "", other.Uuid, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = NewCredentialGroup(c, "/"); err == nil {
+		t.Error("empty group path accepted")
+	}
+	g, err := NewCredentialGroup(c, "/Dev/Git/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Path() != "Dev/Git" {
+		t.Errorf("got path %s, want Dev/Git", g.Path())
+	}
+	if err = g.SetLogin("https://example.com", "bob", Secret("pw"), ""); err == nil {
+		t.Error("stored into a group not opened")
+	}
+	if found, err := g.Open(false); err != nil || found {
+		t.Fatalf("open missing group: %v, %v", found, err)
+	}
+	if found, err := g.Open(true); err != nil || !found || g.Uuid() == "" {
+		t.Fatalf("create group: %v, %v", found, err)
+	}
+	if err = g.SetLogin("https://example.com", "bob", Secret("pw"), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := groupLogins(t, g, "https://example.com"); len(got) != 1 || got[0] != "bob" {
+		t.Errorf("got %v, want the entry of the group only", got)
+	}
+	if got := groupLogins(t, g, "https://other.example.org"); len(got) != 0 {
+		t.Errorf("got %v for an unknown URL", got)
+	}
+
+	// without group uuids only a unique group name is trusted
+	g.client = newStubClient(t, nameOnlyBackend{backend})
+	g.client.AId, g.client.IdKey = c.AId, c.IdKey
+	if _, err = g.client.TestAssociate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Open(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := groupLogins(t, g, "https://example.com"); len(got) != 1 || got[0] != "bob" {
+		t.Errorf("got %v by unique name, want bob", got)
+	}
+	if _, err = c.CreateNewGroup("Work/Git"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Open(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := groupLogins(t, g, "https://example.com"); len(got) != 0 {
+		t.Errorf("got %v by a name another group has", got)
+	}
+}
@@ -0,0 +1,51 @@
+package keepassxc_browser
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// NaclCrypto implements CryptoI on top of golang.org/x/crypto/nacl/box and
+// needs neither cgo nor a system libsodium.
+type NaclCrypto struct{}
+
+func (NaclCrypto) MakeBoxKP() (ret BoxKP, err error) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return ret, err
+	}
+	ret.PublicKey.Bytes = pub[:]
+	ret.SecretKey.Bytes = priv[:]
+
+	return ret, nil
+}
+
+func naclParams(nonce BoxNonce, pubkey BoxPublicKey, privkey BoxSecretKey) (n *[24]byte, pk, sk *[32]byte) {
+	n, pk, sk = new([24]byte), new([32]byte), new([32]byte)
+	copy(n[:], nonce.Bytes)
+	copy(pk[:], pubkey.Bytes)
+	copy(sk[:], privkey.Bytes)
+
+	return n, pk, sk
+}
+
+func (NaclCrypto) Box(data []byte, nonce BoxNonce, pubkey BoxPublicKey, privkey BoxSecretKey) (ret []byte, err error) {
+	n, pk, sk := naclParams(nonce, pubkey, privkey)
+	defer wipeBytes(sk[:])
+
+	return box.Seal(nil, data, n, pk, sk), nil
+}
+
+func (NaclCrypto) BoxOpen(data []byte, nonce BoxNonce, pubkey BoxPublicKey, privkey BoxSecretKey) (ret []byte, err error) {
+	n, pk, sk := naclParams(nonce, pubkey, privkey)
+	defer wipeBytes(sk[:])
+
+	ret, ok := box.Open(nil, data, n, pk, sk)
+	if !ok {
+		return nil, fmt.Errorf("Failed to decrypt message")
+	}
+
+	return ret, nil
+}
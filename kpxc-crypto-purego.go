@@ -0,0 +1,6 @@
+//go:build !cgo || purego
+// +build !cgo purego
+
+package keepassxc_browser
+
+var DefaultCrypto CryptoI = NaclCrypto{}
@@ -0,0 +1,35 @@
+//go:build cgo && !purego
+// +build cgo,!purego
+
+package keepassxc_browser
+
+import (
+	"github.com/jamesruan/sodium"
+)
+
+var DefaultCrypto CryptoI = SodiumCrypto{}
+
+// SodiumCrypto implements CryptoI with libsodium via cgo.
+type SodiumCrypto struct{}
+
+func (SodiumCrypto) MakeBoxKP() (ret BoxKP, err error) {
+	kp := sodium.MakeBoxKP()
+	ret.PublicKey.Bytes = kp.PublicKey.Bytes
+	ret.SecretKey.Bytes = kp.SecretKey.Bytes
+
+	return ret, nil
+}
+
+func (SodiumCrypto) Box(data []byte, nonce BoxNonce, pubkey BoxPublicKey, privkey BoxSecretKey) (ret []byte, err error) {
+	sdata := sodium.Bytes(data)
+	return sdata.Box(sodium.BoxNonce{Bytes: nonce.Bytes},
+		sodium.BoxPublicKey{Bytes: pubkey.Bytes},
+		sodium.BoxSecretKey{Bytes: privkey.Bytes}), nil
+}
+
+func (SodiumCrypto) BoxOpen(data []byte, nonce BoxNonce, pubkey BoxPublicKey, privkey BoxSecretKey) (ret []byte, err error) {
+	sdata := sodium.Bytes(data)
+	return sdata.BoxOpen(sodium.BoxNonce{Bytes: nonce.Bytes},
+		sodium.BoxPublicKey{Bytes: pubkey.Bytes},
+		sodium.BoxSecretKey{Bytes: privkey.Bytes})
+}
@@ -0,0 +1,15 @@
+//go:build cgo && !purego
+// +build cgo,!purego
+
+package keepassxc_browser
+
+import "testing"
+
+func TestSodiumCryptoVectors(t *testing.T) {
+	testBoxVectors(t, SodiumCrypto{})
+}
+
+func TestSodiumNaclInterop(t *testing.T) {
+	testBoxRoundTrip(t, SodiumCrypto{}, NaclCrypto{})
+	testBoxRoundTrip(t, NaclCrypto{}, SodiumCrypto{})
+}
@@ -0,0 +1,96 @@
+package keepassxc_browser
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+const BoxNonceSize int = 24
+const BoxKeySize int = 32
+
+type BoxNonce struct {
+	Bytes []byte
+}
+
+// Next increments the nonce as little endian number, the same way
+// libsodium's sodium_increment does it.
+func (n *BoxNonce) Next() {
+	var c uint16 = 1
+	for i := range n.Bytes {
+		c += uint16(n.Bytes[i])
+		n.Bytes[i] = byte(c)
+		c >>= 8
+	}
+}
+
+type BoxPublicKey struct {
+	Bytes []byte
+}
+
+type BoxSecretKey struct {
+	Bytes []byte
+}
+
+type BoxKP struct {
+	PublicKey BoxPublicKey
+	SecretKey BoxSecretKey
+}
+
+type CryptoI interface {
+	MakeBoxKP() (BoxKP, error)
+	Box(data []byte, nonce BoxNonce, pubkey BoxPublicKey, privkey BoxSecretKey) ([]byte, error)
+	BoxOpen(data []byte, nonce BoxNonce, pubkey BoxPublicKey, privkey BoxSecretKey) ([]byte, error)
+}
+
+// Crypto is the backend used by the package. It defaults to libsodium and
+// to the pure go implementation when built with the purego tag or without cgo.
+var Crypto CryptoI = DefaultCrypto
+
+func checkBoxParams(nonce BoxNonce, pubkey BoxPublicKey, privkey BoxSecretKey) (err error) {
+	if len(nonce.Bytes) != BoxNonceSize {
+		return fmt.Errorf("Invalid nonce size: %d", len(nonce.Bytes))
+	}
+	if len(pubkey.Bytes) != BoxKeySize {
+		return fmt.Errorf("Invalid public key size: %d", len(pubkey.Bytes))
+	}
+	if len(privkey.Bytes) != BoxKeySize {
+		return fmt.Errorf("Invalid secret key size: %d", len(privkey.Bytes))
+	}
+
+	return nil
+}
+
+func wipeBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+func RandomNonce() (ret BoxNonce, err error) {
+	ret.Bytes = make([]byte, BoxNonceSize)
+	if _, err = rand.Read(ret.Bytes); err != nil {
+		return ret, err
+	}
+
+	return ret, nil
+}
+
+func MakeBoxKP() (ret BoxKP, err error) {
+	return Crypto.MakeBoxKP()
+}
+
+func EncryptBytes(nonce BoxNonce, pubkey BoxPublicKey, privkey BoxSecretKey, data []byte) (ret []byte, err error) {
+	if err = checkBoxParams(nonce, pubkey, privkey); err != nil {
+		return nil, err
+	}
+
+	return Crypto.Box(data, nonce, pubkey, privkey)
+}
+
+func DecryptBytes(nonce BoxNonce, pubkey BoxPublicKey, privkey BoxSecretKey, data []byte) (ret []byte, err error) {
+	if err = checkBoxParams(nonce, pubkey, privkey); err != nil {
+		return nil, err
+	}
+
+	return Crypto.BoxOpen(data, nonce, pubkey, privkey)
+}
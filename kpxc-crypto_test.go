@@ -0,0 +1,137 @@
+package keepassxc_browser
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// boxVector is a NaCl crypto_box known answer, ciphertext is what the
+// sender (secret key sk, peer public key peerPk) produces.
+type boxVector struct {
+	sk         string
+	pk         string
+	peerSk     string
+	peerPk     string
+	nonce      string
+	plaintext  string
+	ciphertext string
+}
+
+func rep(b byte, n int) string {
+	return hex.EncodeToString(bytes.Repeat([]byte{b}, n))
+}
+
+var boxVectors = []boxVector{
+	// generated with the C implementation of NaCl, see the nacl/box tests
+	// of golang.org/x/crypto
+	{
+		sk:         rep(2, 32),
+		pk:         "ce8d3ad1ccb633ec7b70c17814a5c76ecd029685050d344745ba05870e587d59",
+		peerSk:     rep(1, 32),
+		peerPk:     "a4e09292b651c278b9772c569f5fa9bb13d906b46ab68c9df9dc2b4409f8a209",
+		nonce:      rep(4, 24),
+		plaintext:  rep(3, 64),
+		ciphertext: "78ea30b19d2341ebbdba54180f821eec265cf86312549bea8a37652a8bb94f07b78a73ed1708085e6ddd0e943bbdeb8755079a37eb31d86163ce241164a47629c0539f330b4914cd135b3855bc2a2dfc",
+	},
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	ret, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+// testBoxVectors checks c against the known answers in both directions.
+func testBoxVectors(t *testing.T, c CryptoI) {
+	for i, v := range boxVectors {
+		nonce := BoxNonce{mustHex(t, v.nonce)}
+		sk := BoxSecretKey{mustHex(t, v.sk)}
+		peerPk := BoxPublicKey{mustHex(t, v.peerPk)}
+		plaintext := mustHex(t, v.plaintext)
+		ciphertext := mustHex(t, v.ciphertext)
+
+		ret, err := c.Box(plaintext, nonce, peerPk, sk)
+		if err != nil {
+			t.Fatalf("vector %d: Box: %v", i, err)
+		}
+		if !bytes.Equal(ret, ciphertext) {
+			t.Errorf("vector %d: Box got\n%x\nwant\n%x", i, ret, ciphertext)
+		}
+
+		ret, err = c.BoxOpen(ciphertext, nonce, BoxPublicKey{mustHex(t, v.pk)}, BoxSecretKey{mustHex(t, v.peerSk)})
+		if err != nil {
+			t.Fatalf("vector %d: BoxOpen: %v", i, err)
+		}
+		if !bytes.Equal(ret, plaintext) {
+			t.Errorf("vector %d: BoxOpen got %x, want %x", i, ret, plaintext)
+		}
+
+		ciphertext[len(ciphertext)-1] ^= 1
+		if _, err = c.BoxOpen(ciphertext, nonce, BoxPublicKey{mustHex(t, v.pk)}, BoxSecretKey{mustHex(t, v.peerSk)}); err == nil {
+			t.Errorf("vector %d: BoxOpen accepted a modified ciphertext", i)
+		}
+	}
+}
+
+// testBoxRoundTrip boxes with a and opens with b.
+func testBoxRoundTrip(t *testing.T, a, b CryptoI) {
+	alice, err := a.MakeBoxKP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := b.MakeBoxKP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce, err := RandomNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := []byte(`{"action":"get-databasehash"}`)
+	ret, err := a.Box(msg, nonce, bob.PublicKey, alice.SecretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, err = b.BoxOpen(ret, nonce, alice.PublicKey, bob.SecretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ret, msg) {
+		t.Errorf("got %q, want %q", ret, msg)
+	}
+}
+
+func TestNaclCryptoVectors(t *testing.T) {
+	testBoxVectors(t, NaclCrypto{})
+}
+
+func TestNaclCryptoRoundTrip(t *testing.T) {
+	testBoxRoundTrip(t, NaclCrypto{}, NaclCrypto{})
+}
+
+func TestBoxNonceNext(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{rep(0, 24), "01" + rep(0, 23)},
+		{"fe" + rep(0, 23), "ff" + rep(0, 23)},
+		// the carry runs into the following bytes, little endian
+		{"ff" + rep(0, 23), "0001" + rep(0, 22)},
+		{"ffff01" + rep(0, 21), "000002" + rep(0, 21)},
+		{rep(0xff, 23) + "00", rep(0, 23) + "01"},
+		// and wraps around
+		{rep(0xff, 24), rep(0, 24)},
+	}
+	for _, tt := range tests {
+		n := BoxNonce{mustHex(t, tt.in)}
+		n.Next()
+		if got := hex.EncodeToString(n.Bytes); got != tt.want {
+			t.Errorf("Next(%s) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package keepassxc_browser
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Error codes of the KeePassXC browser protocol.
+const (
+	ErrCodeDatabaseNotOpened          int = 1
+	ErrCodeDatabaseHashNotReceived    int = 2
+	ErrCodeClientPublicKeyNotReceived int = 3
+	ErrCodeCannotDecryptMessage       int = 4
+	ErrCodeTimeoutOrNotConnected      int = 5
+	ErrCodeActionCancelledOrDenied    int = 6
+	ErrCodeCannotEncryptMessage       int = 7
+	ErrCodeAssociationFailed          int = 8
+	ErrCodeKeyChangeFailed            int = 9
+	ErrCodeEncryptionKeyUnrecognized  int = 10
+	ErrCodeNoSavedDatabasesFound      int = 11
+	ErrCodeIncorrectAction            int = 12
+	ErrCodeEmptyMessageReceived       int = 13
+	ErrCodeNoUrlProvided              int = 14
+	ErrCodeNoLoginsFound              int = 15
+	ErrCodeNoGroupsFound              int = 16
+	ErrCodeCannotCreateNewGroup       int = 17
+	ErrCodeNoValidUuidProvided        int = 18
+	ErrCodeAccessToAllEntriesDenied   int = 19
+)
+
+// ProtocolError is an error reported by the other side of the browser
+// protocol, or one to be reported to it.
+type ProtocolError struct {
+	Code    int
+	Message string
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("Error: %s (Code: %d)", e.Message, e.Code)
+}
+
+func NewProtocolError(code int, message string) *ProtocolError {
+	return &ProtocolError{Code: code, Message: message}
+}
+
+// ErrorReply builds the error response to req. Errors other than
+// *ProtocolError are sent as ErrCodeActionCancelledOrDenied.
+func ErrorReply(req *ConnMsg, err error) (ret []byte, rerr error) {
+	perr, ok := err.(*ProtocolError)
+	if !ok {
+		perr = NewProtocolError(ErrCodeActionCancelledOrDenied, err.Error())
+	}
+
+	res := &ConnMsg{
+		ActionName: req.ActionName,
+		RequestId:  req.RequestId,
+		Error:      perr.Message,
+		ErrorCode:  fmt.Sprint(perr.Code),
+	}
+
+	return json.Marshal(res)
+}
@@ -0,0 +1,44 @@
+package keepassxc_browser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+func writeFrame(w io.Writer, order binary.ByteOrder, message []byte) (err error) {
+	frame := make([]byte, 4+len(message))
+	order.PutUint32(frame, uint32(len(message)))
+	copy(frame[4:], message)
+	_, err = w.Write(frame)
+
+	return err
+}
+
+func readFrame(r io.Reader, order binary.ByteOrder, maxsize int) (ret []byte, err error) {
+	blen := make([]byte, 4)
+	if _, err = io.ReadFull(r, blen); err != nil {
+		return nil, err
+	}
+
+	rlen := order.Uint32(blen)
+	if maxsize > 0 && rlen > uint32(maxsize) {
+		// skip it, the next frame is read fine
+		if _, err = io.CopyN(io.Discard, r, int64(rlen)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		return nil, fmt.Errorf("Message too large: %d > %d", rlen, maxsize)
+	}
+	ret = make([]byte, rlen)
+	if _, err = io.ReadFull(r, ret); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,607 @@
+package keepassxc_browser
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tobischo/gokeepasslib/v3"
+	w "github.com/tobischo/gokeepasslib/v3/wrappers"
+)
+
+const KdbxDefaultGroup string = "KeePassXC-Browser Passwords"
+const kdbxAssocPrefix string = "KPXC_BROWSER_"
+const kdbxPasswordChars string = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_!$%&/()=?*+#.,;:"
+
+// KdbxApproveFunc decides about an associate request of a client and
+// returns the id of the new association.
+type KdbxApproveFunc func(clientId, idKey string) (id string, err error)
+
+type KdbxOption func(*KdbxBackend) error
+
+// KdbxBackend is a BrowserBackend serving the entries of a KDBX file, so
+// the browser integration works without a running KeePassXC. Associations
+// are stored in the database like KeePassXC does it.
+type KdbxBackend struct {
+	mu      sync.Mutex
+	path    string
+	creds   *gokeepasslib.DBCredentials
+	db      *gokeepasslib.Database
+	approve KdbxApproveFunc
+	now     func() time.Time
+}
+
+// WithKdbxApprove sets the callback deciding about new associations. By
+// default every associate request is denied.
+func WithKdbxApprove(approve KdbxApproveFunc) KdbxOption {
+	return func(b *KdbxBackend) error {
+		b.approve = approve
+		return nil
+	}
+}
+
+// KdbxAutoApprove approves every client, the association is named after
+// the client id.
+func KdbxAutoApprove(clientId, idKey string) (id string, err error) {
+	return clientId, nil
+}
+
+func (b *KdbxBackend) load() (err error) {
+	f, err := os.Open(b.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	db := gokeepasslib.NewDatabase()
+	db.Credentials = b.creds
+	if err = gokeepasslib.NewDecoder(f).Decode(db); err != nil {
+		return err
+	}
+	if err = db.UnlockProtectedEntries(); err != nil {
+		return err
+	}
+	if len(db.Content.Root.Groups) == 0 {
+		return fmt.Errorf("Database has no root group")
+	}
+	b.db = db
+
+	return nil
+}
+
+func (b *KdbxBackend) save() (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(b.path), ".kpxc-*.kdbx")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = b.db.LockProtectedEntries(); err != nil {
+		tmp.Close()
+		return err
+	}
+	err = gokeepasslib.NewEncoder(tmp).Encode(b.db)
+	if uerr := b.db.UnlockProtectedEntries(); err == nil {
+		err = uerr
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), b.path)
+}
+
+func (b *KdbxBackend) opened() (err error) {
+	if b.db == nil {
+		return NewProtocolError(ErrCodeDatabaseNotOpened, "Database not opened")
+	}
+
+	return nil
+}
+
+func (b *KdbxBackend) root() *gokeepasslib.Group {
+	return &b.db.Content.Root.Groups[0]
+}
+
+func kdbxUuid(u gokeepasslib.UUID) string {
+	return hex.EncodeToString(u[:])
+}
+
+func (b *KdbxBackend) hash() string {
+	sum := sha256.Sum256([]byte(kdbxUuid(b.root().UUID)))
+	return hex.EncodeToString(sum[:])
+}
+
+func (b *KdbxBackend) isRecycleBin(g *gokeepasslib.Group) bool {
+	meta := b.db.Content.Meta
+	return meta != nil && meta.RecycleBinEnabled.Bool && g.UUID.Compare(meta.RecycleBinUUID)
+}
+
+func (b *KdbxBackend) walkEntries(g *gokeepasslib.Group, fn func(*gokeepasslib.Group, *gokeepasslib.Entry)) {
+	if b.isRecycleBin(g) {
+		return
+	}
+	for i := range g.Entries {
+		fn(g, &g.Entries[i])
+	}
+	for i := range g.Groups {
+		b.walkEntries(&g.Groups[i], fn)
+	}
+}
+
+func (b *KdbxBackend) findEntry(uuid string) (ret *gokeepasslib.Entry) {
+	b.walkEntries(b.root(), func(g *gokeepasslib.Group, e *gokeepasslib.Entry) {
+		if ret == nil && strings.EqualFold(kdbxUuid(e.UUID), uuid) {
+			ret = e
+		}
+	})
+
+	return ret
+}
+
+func findGroup(g *gokeepasslib.Group, uuid string) *gokeepasslib.Group {
+	if strings.EqualFold(kdbxUuid(g.UUID), uuid) {
+		return g
+	}
+	for i := range g.Groups {
+		if ret := findGroup(&g.Groups[i], uuid); ret != nil {
+			return ret
+		}
+	}
+
+	return nil
+}
+
+func (b *KdbxBackend) assocKey(id string) string {
+	if b.db.Content.Meta == nil {
+		return ""
+	}
+	for _, cd := range b.db.Content.Meta.CustomData {
+		if cd.Key == kdbxAssocPrefix+id {
+			return cd.Value
+		}
+	}
+
+	return ""
+}
+
+func (b *KdbxBackend) associated(keys []key) bool {
+	for _, k := range keys {
+		if k.Id != "" && k.Key != "" && b.assocKey(k.Id) == k.Key {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b *KdbxBackend) GetDatabasehash(clientId string, req *MsgGetDatabasehash) (ret *MsgGetDatabasehash, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err = b.opened(); err != nil {
+		return nil, err
+	}
+
+	ret = new(MsgGetDatabasehash)
+	ret.Hash = b.hash()
+
+	return ret, nil
+}
+
+func (b *KdbxBackend) Associate(clientId string, req *MsgAssociate) (ret *MsgAssociate, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err = b.opened(); err != nil {
+		return nil, err
+	}
+	if req.Key == "" || req.IdKey == "" {
+		return nil, NewProtocolError(ErrCodeAssociationFailed, "Association failed")
+	}
+	if b.approve == nil {
+		return nil, NewProtocolError(ErrCodeActionCancelledOrDenied, "Action cancelled or denied")
+	}
+
+	id, err := b.approve(clientId, req.IdKey)
+	if err != nil {
+		return nil, err
+	}
+	if id == "" {
+		return nil, NewProtocolError(ErrCodeAssociationFailed, "Association failed")
+	}
+
+	if b.db.Content.Meta == nil {
+		b.db.Content.Meta = gokeepasslib.NewMetaData()
+	}
+	meta := b.db.Content.Meta
+	found := false
+	for i := range meta.CustomData {
+		if meta.CustomData[i].Key == kdbxAssocPrefix+id {
+			meta.CustomData[i].Value = req.IdKey
+			found = true
+		}
+	}
+	if !found {
+		meta.CustomData = append(meta.CustomData, gokeepasslib.CustomData{Key: kdbxAssocPrefix + id, Value: req.IdKey})
+	}
+	if err = b.save(); err != nil {
+		return nil, err
+	}
+
+	ret = new(MsgAssociate)
+	ret.Id = id
+	ret.Hash = b.hash()
+
+	return ret, nil
+}
+
+func (b *KdbxBackend) TestAssociate(clientId string, req *MsgAssociate) (ret *MsgAssociate, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err = b.opened(); err != nil {
+		return nil, err
+	}
+	if !b.associated([]key{{Id: req.Id, Key: req.Key}}) {
+		return nil, NewProtocolError(ErrCodeAssociationFailed, "Association failed")
+	}
+
+	ret = new(MsgAssociate)
+	ret.Id = req.Id
+	ret.Hash = b.hash()
+
+	return ret, nil
+}
+
+func (b *KdbxBackend) GeneratePassword(clientId string, req *MsgGeneratePassword) (ret *MsgGeneratePassword, err error) {
+	pw := make(Secret, 20)
+	max := big.NewInt(int64(len(kdbxPasswordChars)))
+	for i := range pw {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			pw.Wipe()
+			return nil, err
+		}
+		pw[i] = kdbxPasswordChars[n.Int64()]
+	}
+
+	ret = new(MsgGeneratePassword)
+	ret.Password = pw
+
+	return ret, nil
+}
+
+func (b *KdbxBackend) entryUrls(e *gokeepasslib.Entry) (ret []string) {
+	if u := e.GetContent("URL"); u != "" {
+		ret = append(ret, u)
+	}
+	for _, v := range e.Values {
+		if strings.HasPrefix(v.Key, "KP2A_URL") && v.Value.Content != "" {
+			ret = append(ret, v.Value.Content)
+		}
+	}
+
+	return ret
+}
+
+func (b *KdbxBackend) loginEntry(g *gokeepasslib.Group, e *gokeepasslib.Entry) (ret LoginEntry) {
+	ret.Login = e.GetContent("UserName")
+	ret.Group = g.Name
+	ret.GroupUuid = kdbxUuid(g.UUID)
+	ret.Name = e.GetTitle()
+	ret.Password = Secret(e.GetPassword())
+	ret.Uuid = kdbxUuid(e.UUID)
+	if e.Times.Expires.Bool && e.Times.ExpiryTime != nil && e.Times.ExpiryTime.Time.Before(b.now()) {
+		ret.Expired = "true"
+	}
+	for _, v := range e.Values {
+		if strings.HasPrefix(v.Key, "KPH: ") {
+			ret.StringFields = append(ret.StringFields, map[string]string{v.Key: v.Value.Content})
+		}
+	}
+
+	return ret
+}
+
+func (b *KdbxBackend) GetLogins(clientId string, req *MsgGetLogins) (ret *MsgGetLogins, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err = b.opened(); err != nil {
+		return nil, err
+	}
+	if !b.associated(req.Keys) {
+		return nil, NewProtocolError(ErrCodeAssociationFailed, "Association failed")
+	}
+	if req.Url == "" {
+		return nil, NewProtocolError(ErrCodeNoUrlProvided, "No URL provided")
+	}
+
+	type match struct {
+		level int
+		entry LoginEntry
+	}
+	var matches []match
+	b.walkEntries(b.root(), func(g *gokeepasslib.Group, e *gokeepasslib.Entry) {
+		level := UrlMatchNone
+		for _, u := range b.entryUrls(e) {
+			if l := MatchUrl(u, req.Url); l > level {
+				level = l
+			}
+		}
+		if level > UrlMatchNone {
+			matches = append(matches, match{level: level, entry: b.loginEntry(g, e)})
+		}
+	})
+	if len(matches) == 0 {
+		return nil, NewProtocolError(ErrCodeNoLoginsFound, "No logins found")
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].level > matches[j].level
+	})
+
+	ret = new(MsgGetLogins)
+	ret.Hash = b.hash()
+	for _, m := range matches {
+		ret.Entries = append(ret.Entries, m.entry)
+	}
+	ret.Count = len(ret.Entries)
+
+	return ret, nil
+}
+
+func setEntryValue(e *gokeepasslib.Entry, key, value string, protected bool) {
+	if v := e.Get(key); v != nil {
+		v.Value.Content = value
+		return
+	}
+	e.Values = append(e.Values, gokeepasslib.ValueData{
+		Key:   key,
+		Value: gokeepasslib.V{Content: value, Protected: w.NewBoolWrapper(protected)},
+	})
+}
+
+func (b *KdbxBackend) defaultGroup() *gokeepasslib.Group {
+	root := b.root()
+	for i := range root.Groups {
+		if root.Groups[i].Name == KdbxDefaultGroup {
+			return &root.Groups[i]
+		}
+	}
+	g := gokeepasslib.NewGroup()
+	g.Name = KdbxDefaultGroup
+	root.Groups = append(root.Groups, g)
+
+	return &root.Groups[len(root.Groups)-1]
+}
+
+func (b *KdbxBackend) SetLogin(clientId string, req *MsgSetLogin) (ret *MsgSetLogin, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err = b.opened(); err != nil {
+		return nil, err
+	}
+	if req.Url == "" {
+		return nil, NewProtocolError(ErrCodeNoUrlProvided, "No URL provided")
+	}
+
+	if req.Uuid != "" {
+		e := b.findEntry(req.Uuid)
+		if e == nil {
+			return nil, NewProtocolError(ErrCodeNoValidUuidProvided, "No valid UUID provided")
+		}
+		setEntryValue(e, "UserName", req.Login, false)
+		setEntryValue(e, "Password", string(req.Password), true)
+		now := b.now()
+		e.Times.LastModificationTime = &w.TimeWrapper{Time: now}
+	} else {
+		var g *gokeepasslib.Group
+		if req.GroupUuid != "" {
+			if g = findGroup(b.root(), req.GroupUuid); g == nil {
+				return nil, NewProtocolError(ErrCodeNoValidUuidProvided, "No valid UUID provided")
+			}
+		} else {
+			g = b.defaultGroup()
+		}
+
+		e := gokeepasslib.NewEntry()
+		title := UrlHost(req.Url)
+		if title == "" {
+			title = req.Url
+		}
+		setEntryValue(&e, "Title", title, false)
+		setEntryValue(&e, "UserName", req.Login, false)
+		setEntryValue(&e, "Password", string(req.Password), true)
+		setEntryValue(&e, "URL", req.Url, false)
+		g.Entries = append(g.Entries, e)
+	}
+
+	if err = b.save(); err != nil {
+		return nil, err
+	}
+
+	ret = new(MsgSetLogin)
+	ret.Count = 1
+
+	return ret, nil
+}
+
+func (b *KdbxBackend) LockDatabase(clientId string, req *MsgLockDatabase) (ret *MsgLockDatabase, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err = b.opened(); err != nil {
+		return nil, err
+	}
+	b.db = nil
+	wipeCredentials(b.creds)
+	b.creds = nil
+
+	return new(MsgLockDatabase), nil
+}
+
+// wipeCredentials wipes the key hashes of creds.
+func wipeCredentials(creds *gokeepasslib.DBCredentials) {
+	if creds == nil {
+		return
+	}
+	wipeBytes(creds.Passphrase)
+	wipeBytes(creds.Key)
+	wipeBytes(creds.Windows)
+}
+
+// Unlock reopens the database after it was locked with lock-database, the
+// backend owns creds and wipes them when it is locked again.
+func (b *KdbxBackend) Unlock(creds *gokeepasslib.DBCredentials) (err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.creds = creds
+	if err = b.load(); err != nil {
+		wipeCredentials(b.creds)
+		b.creds = nil
+		return err
+	}
+
+	return nil
+}
+
+func (b *KdbxBackend) groupChild(g *gokeepasslib.Group) (ret GroupChild) {
+	ret.Name = g.Name
+	ret.Uuid = kdbxUuid(g.UUID)
+	ret.Children = []GroupChild{}
+	for i := range g.Groups {
+		if b.isRecycleBin(&g.Groups[i]) {
+			continue
+		}
+		ret.Children = append(ret.Children, b.groupChild(&g.Groups[i]))
+	}
+
+	return ret
+}
+
+func (b *KdbxBackend) GetDatabaseGroups(clientId string, req *MsgGetDatabaseGroups) (ret *MsgGetDatabaseGroups, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err = b.opened(); err != nil {
+		return nil, err
+	}
+
+	ret = new(MsgGetDatabaseGroups)
+	ret.Groups.Groups = []GroupChild{b.groupChild(b.root())}
+
+	return ret, nil
+}
+
+func (b *KdbxBackend) CreateNewGroup(clientId string, req *MsgCreateNewGroup) (ret *MsgCreateNewGroup, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err = b.opened(); err != nil {
+		return nil, err
+	}
+
+	g := b.root()
+	created := false
+	for _, name := range strings.Split(req.GroupName, "/") {
+		if name == "" {
+			continue
+		}
+		var next *gokeepasslib.Group
+		for i := range g.Groups {
+			if g.Groups[i].Name == name {
+				next = &g.Groups[i]
+				break
+			}
+		}
+		if next == nil {
+			ng := gokeepasslib.NewGroup()
+			ng.Name = name
+			g.Groups = append(g.Groups, ng)
+			next = &g.Groups[len(g.Groups)-1]
+			created = true
+		}
+		g = next
+	}
+	if g == b.root() {
+		return nil, NewProtocolError(ErrCodeCannotCreateNewGroup, "Cannot create new group")
+	}
+
+	if created {
+		if err = b.save(); err != nil {
+			return nil, err
+		}
+	}
+
+	ret = new(MsgCreateNewGroup)
+	ret.Name = g.Name
+	ret.Uuid = kdbxUuid(g.UUID)
+
+	return ret, nil
+}
+
+func (b *KdbxBackend) GetTotp(clientId string, req *MsgGetTotp) (ret *MsgGetTotp, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err = b.opened(); err != nil {
+		return nil, err
+	}
+
+	e := b.findEntry(req.Uuid)
+	if e == nil {
+		return nil, NewProtocolError(ErrCodeNoValidUuidProvided, "No valid UUID provided")
+	}
+
+	otp := e.GetContent("otp")
+	if otp == "" {
+		// legacy KeePassXC attributes
+		if seed := e.GetContent("TOTP Seed"); seed != "" {
+			otp = seed
+		}
+	}
+	if otp == "" {
+		return nil, NewProtocolError(ErrCodeNoLoginsFound, "No TOTP configured")
+	}
+
+	settings, err := ParseTotp(otp)
+	if err != nil {
+		return nil, err
+	}
+	defer settings.Wipe()
+
+	ret = new(MsgGetTotp)
+	ret.Uuid = req.Uuid
+	if ret.Totp, err = settings.Generate(b.now()); err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// NewKdbxBackend opens the KDBX file at path with creds, see the
+// gokeepasslib New*Credentials functions for password and key file unlock.
+// The backend owns creds, lock-database wipes them.
+func NewKdbxBackend(path string, creds *gokeepasslib.DBCredentials, opts ...KdbxOption) (ret *KdbxBackend, err error) {
+	ret = new(KdbxBackend)
+	ret.path = path
+	ret.creds = creds
+	ret.now = time.Now
+
+	for _, opt := range opts {
+		if err = opt(ret); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = ret.load(); err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
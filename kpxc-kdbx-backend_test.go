@@ -0,0 +1,130 @@
+package keepassxc_browser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+const testKdbxPassword string = "test"
+
+// newKdbxFile writes an empty database with a root group to a temporary
+// file, it is unlocked with testKdbxPassword.
+func newKdbxFile(t *testing.T) (path string) {
+	t.Helper()
+	db := gokeepasslib.NewDatabase()
+	db.Credentials = gokeepasslib.NewPasswordCredentials(testKdbxPassword)
+	root := gokeepasslib.NewGroup()
+	root.Name = "Root"
+	db.Content.Root.Groups = []gokeepasslib.Group{root}
+	if err := db.LockProtectedEntries(); err != nil {
+		t.Fatal(err)
+	}
+
+	path = filepath.Join(t.TempDir(), "test.kdbx")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err = gokeepasslib.NewEncoder(f).Encode(db); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// newKdbxClient returns a client associated with a KdbxBackend on a new
+// database.
+func newKdbxClient(t *testing.T) (c *Client, backend *KdbxBackend) {
+	t.Helper()
+	backend, err := NewKdbxBackend(newKdbxFile(t), gokeepasslib.NewPasswordCredentials(testKdbxPassword),
+		WithKdbxApprove(KdbxAutoApprove))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = newStubClient(t, backend)
+	if _, err = c.Associate(); err != nil {
+		t.Fatal(err)
+	}
+
+	return c, backend
+}
+
+func TestKdbxBackendRoundTrip(t *testing.T) {
+	path := newKdbxFile(t)
+	creds := gokeepasslib.NewPasswordCredentials(testKdbxPassword)
+	b, err := NewKdbxBackend(path, creds, WithKdbxApprove(KdbxAutoApprove))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a database without meta data
+	b.db.Content.Meta = nil
+	if _, err = b.TestAssociate("c", &MsgAssociate{MsgBase: MsgBase{Id: "c"}, Key: "k"}); err == nil {
+		t.Fatal("test-associate without meta data succeeded")
+	}
+	if _, err = b.Associate("c", &MsgAssociate{Key: "pub", IdKey: "k"}); err != nil {
+		t.Fatal(err)
+	}
+	keys := []key{{Id: "c", Key: "k"}}
+	g, err := b.CreateNewGroup("c", &MsgCreateNewGroup{GroupName: "Web/Shop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = b.SetLogin("c", &MsgSetLogin{Url: "https://shop.example.com", Login: "bob", Password: Secret("pw"), GroupUuid: g.Uuid}); err != nil {
+		t.Fatal(err)
+	}
+	logins, err := b.GetLogins("c", &MsgGetLogins{Url: "https://shop.example.com", Keys: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uuid := logins.Entries[0].Uuid
+	setEntryValue(b.findEntry(uuid), "otp", "otpauth://totp/x?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&digits=8", true)
+	if err = b.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the lock wipes the credentials
+	if _, err = b.LockDatabase("c", &MsgLockDatabase{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range creds.Passphrase {
+		if c != 0 {
+			t.Fatal("credentials not wiped")
+		}
+	}
+
+	b, err = NewKdbxBackend(path, gokeepasslib.NewPasswordCredentials(testKdbxPassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.now = func() time.Time { return time.Unix(59, 0) }
+	if _, err = b.TestAssociate("c", &MsgAssociate{MsgBase: MsgBase{Id: "c"}, Key: "k"}); err != nil {
+		t.Errorf("association lost: %v", err)
+	}
+	logins, err = b.GetLogins("c", &MsgGetLogins{Url: "https://shop.example.com/cart", Keys: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := logins.Entries[0]
+	if e.Uuid != uuid || e.Login != "bob" || string(e.Password) != "pw" || e.Group != "Shop" || e.GroupUuid != g.Uuid {
+		t.Errorf("got entry %+v", e)
+	}
+	totp, err := b.GetTotp("c", &MsgGetTotp{Uuid: uuid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(totp.Totp) != "94287082" {
+		t.Errorf("got TOTP %s, want 94287082", totp.Totp)
+	}
+	groups, err := b.GetDatabaseGroups("c", &MsgGetDatabaseGroups{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uuid, ok := FindGroupPath(groups.Groups.Groups[0].Children, []string{"Web", "Shop"}); !ok || uuid != g.Uuid {
+		t.Errorf("group Web/Shop lost: %v", groups.Groups)
+	}
+}
@@ -0,0 +1,10 @@
+package keepassxc_browser
+
+// LoggerI is satisfied by *log.Logger.
+type LoggerI interface {
+	Printf(format string, v ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Printf(format string, v ...interface{}) {}
@@ -4,6 +4,7 @@ import "fmt"
 
 type MsgI interface {
 	IsSuccess() bool
+	Wipe()
 }
 
 type MsgBase struct {
@@ -19,6 +20,12 @@ func (m *MsgBase) IsSuccess() bool {
 	return m.Success == "true"
 }
 
+func (m *MsgBase) Wipe() {}
+
+func (m *MsgBase) base() *MsgBase {
+	return m
+}
+
 type MsgGetDatabasehash struct {
 	MsgBase
 }
@@ -31,7 +38,11 @@ type MsgAssociate struct {
 
 type MsgGeneratePassword struct {
 	MsgBase
-	Password string `json:"password"`
+	Password Secret `json:"password"`
+}
+
+func (m *MsgGeneratePassword) Wipe() {
+	m.Password.Wipe()
 }
 
 type key struct {
@@ -42,12 +53,20 @@ type key struct {
 type LoginEntry struct {
 	Login        string              `json:"login"`
 	Name         string              `json:"name"`
-	Password     string              `json:"password"`
+	Password     Secret              `json:"password"`
 	Expired      string              `json:"expired,omitempty"`
 	Uuid         string              `json:"uuid"`
+	Group        string              `json:"group,omitempty"`
+	GroupUuid    string              `json:"groupUuid,omitempty"`
+	Totp         Secret              `json:"totp,omitempty"`
 	StringFields []map[string]string `json:"stringFields"`
 }
 
+func (e *LoginEntry) Wipe() {
+	e.Password.Wipe()
+	e.Totp.Wipe()
+}
+
 type MsgGetLogins struct {
 	MsgBase
 	Url       string       `json:"url"`
@@ -58,12 +77,18 @@ type MsgGetLogins struct {
 	Count     int          `json:"count"`
 }
 
+func (m *MsgGetLogins) Wipe() {
+	for i := range m.Entries {
+		m.Entries[i].Wipe()
+	}
+}
+
 type MsgSetLogin struct {
 	MsgBase
 	Url             string       `json:"url"`
 	SubmitUrl       string       `json:"submitUrl"`
 	Login           string       `json:"login"`
-	Password        string       `json:"password"`
+	Password        Secret       `json:"password"`
 	Group           string       `json:"group"`
 	GroupUuid       string       `json:"groupUuid"`
 	Uuid            string       `json:"uuid"`
@@ -72,6 +97,13 @@ type MsgSetLogin struct {
 	Count           int          `json:"count"`
 }
 
+func (m *MsgSetLogin) Wipe() {
+	m.Password.Wipe()
+	for i := range m.Entries {
+		m.Entries[i].Wipe()
+	}
+}
+
 type MsgLockDatabase struct {
 	MsgBase
 }
@@ -100,11 +132,22 @@ type MsgGetDatabaseGroups struct {
 type MsgCreateNewGroup struct {
 	MsgBase
 	GroupName string `json:"groupName"`
+	Name      string `json:"name,omitempty"`
+	Uuid      string `json:"uuid,omitempty"`
 }
 
 type MsgGetTotp struct {
 	MsgBase
-	Totp string `json:"totp"`
+	Totp Secret `json:"totp"`
+	Uuid string `json:"uuid"`
+}
+
+func (m *MsgGetTotp) Wipe() {
+	m.Totp.Wipe()
+}
+
+type MsgDeleteEntry struct {
+	MsgBase
 	Uuid string `json:"uuid"`
 }
 
@@ -130,6 +173,8 @@ func GetMessageType(action string) (ret MsgI, err error) {
 		return &MsgCreateNewGroup{MsgBase: MsgBase{ActionName: action}}, nil
 	case "get-totp":
 		return &MsgGetTotp{MsgBase: MsgBase{ActionName: action}}, nil
+	case "delete-entry":
+		return &MsgDeleteEntry{MsgBase: MsgBase{ActionName: action}}, nil
 	}
 
 	return nil, fmt.Errorf("Unknown action: %s", action)
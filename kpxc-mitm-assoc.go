@@ -0,0 +1,347 @@
+package keepassxc_browser
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// MediatorRequestTimeout is how long the AssocMediator keeps the state of
+// a forwarded request waiting for its response.
+var MediatorRequestTimeout = time.Minute
+
+// VirtualAssociation is an association the AssocMediator handed to a
+// downstream client, it is only valid for the database it was made for.
+type VirtualAssociation struct {
+	ClientId string `json:"clientId"`
+	IdKey    string `json:"idKey"`
+	Hash     string `json:"hash"`
+}
+
+// AssocMediator is a KpXcMitmI holding a single association with KeePassXC
+// per database and presenting virtual associations to the clients. The
+// user approves the MITM once in KeePassXC, further clients are approved
+// by Policy, which decides about their associate requests. Without a
+// policy all clients are denied.
+//
+// Clients have to query get-databasehash before associate, as the browser
+// extension and Client do, so the mediator knows the active database. Other
+// actions carrying an id only get it mapped once the client proved the
+// virtual association since its last key exchange, so the mediator has to
+// see the key exchanges: put it before modifiers denying change-public-keys.
+type AssocMediator struct {
+	IdKey    string                        `json:"idKey"`
+	Upstream map[string]string             `json:"upstream"`
+	Clients  map[string]VirtualAssociation `json:"clients"`
+	mu       sync.Mutex
+	file     string
+	policy   *Policy
+	logger   LoggerI
+	hashes   map[string]string
+	pending  map[*ConnMsg]pendingReq
+	proven   map[string]map[string]bool
+}
+
+// pendingReq is the state of a forwarded associate or test-associate, the
+// id key of the client or the virtual id.
+type pendingReq struct {
+	value string
+	added time.Time
+}
+
+// hold keeps value until the response to req, the requests themselves are
+// the keys as the request ids are chosen by the clients.
+func (m *AssocMediator) hold(req *ConnMsg, value string) {
+	now := time.Now()
+	for r, p := range m.pending {
+		// never answered
+		if now.Sub(p.added) > MediatorRequestTimeout {
+			delete(m.pending, r)
+		}
+	}
+	m.pending[req] = pendingReq{value: value, added: now}
+}
+
+func (m *AssocMediator) release(req *ConnMsg) (ret string) {
+	ret = m.pending[req].value
+	delete(m.pending, req)
+
+	return ret
+}
+
+// prove records that the client of the current key exchange holds the
+// virtual association id.
+func (m *AssocMediator) prove(clientId, id string) {
+	if m.proven[clientId] == nil {
+		m.proven[clientId] = make(map[string]bool)
+	}
+	m.proven[clientId][id] = true
+}
+
+func (m *AssocMediator) load() (err error) {
+	data, err := os.ReadFile(m.file)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, m)
+}
+
+// save replaces the state file atomically, it holds the identity keys.
+func (m *AssocMediator) save() (err error) {
+	if m.file == "" {
+		return nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(m.file), ".kpxc-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err = tmp.Chmod(0600); err == nil {
+		_, err = tmp.Write(data)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), m.file)
+}
+
+// client returns the virtual association matching id and idKey.
+func (m *AssocMediator) client(id, idKey string) (ret VirtualAssociation, ok bool) {
+	ret, ok = m.Clients[id]
+	if !ok || ret.IdKey == "" || ret.IdKey != idKey {
+		return ret, false
+	}
+
+	return ret, true
+}
+
+func (m *AssocMediator) addClient(clientId, idKey, hash string) (id string, err error) {
+	if id, err = RandomRequestID(); err != nil {
+		return "", err
+	}
+	m.Clients[id] = VirtualAssociation{ClientId: clientId, IdKey: idKey, Hash: hash}
+	if err = m.save(); err != nil {
+		delete(m.Clients, id)
+		return "", err
+	}
+	m.logger.Printf("Associated client %s as %s\n", clientId, id)
+
+	return id, nil
+}
+
+func (m *AssocMediator) associate(req *ConnMsg, d *MsgAssociate) (err error) {
+	if d.IdKey == "" {
+		return NewProtocolError(ErrCodeAssociationFailed, "Association failed")
+	}
+	effect, name := PolicyDeny, "no policy"
+	if m.policy != nil {
+		effect, name = m.policy.Effect(req)
+	}
+	if effect == PolicyDeny {
+		m.logger.Printf("Denied association of client %s by rule %s\n", req.ClientId, name)
+		return NewProtocolError(ErrCodeActionCancelledOrDenied, "Action cancelled or denied")
+	}
+
+	hash := m.hashes[req.ClientId]
+	if hash != "" && m.Upstream[hash] != "" {
+		id, err := m.addClient(req.ClientId, d.IdKey, hash)
+		if err != nil {
+			return err
+		}
+		m.prove(req.ClientId, id)
+		ret := new(MsgAssociate)
+		ret.Id = id
+		ret.Hash = hash
+		return &MitmReply{Data: ret}
+	}
+
+	// first client for this database, KeePassXC asks the user to approve
+	// the MITM itself
+	m.hold(req, d.IdKey)
+	d.IdKey = m.IdKey
+
+	return nil
+}
+
+func (m *AssocMediator) testAssociate(req *ConnMsg, d *MsgAssociate) (err error) {
+	va, ok := m.client(d.Id, d.Key)
+	if !ok || m.Upstream[va.Hash] == "" {
+		return NewProtocolError(ErrCodeAssociationFailed, "Association failed")
+	}
+	m.hold(req, d.Id)
+	d.Id = m.Upstream[va.Hash]
+	d.Key = m.IdKey
+
+	return nil
+}
+
+// keys maps the virtual associations of a get-logins request to the
+// upstream ones, unknown keys are dropped.
+func (m *AssocMediator) keys(keys []key) (ret []key) {
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		va, ok := m.client(k.Id, k.Key)
+		if !ok {
+			continue
+		}
+		id := m.Upstream[va.Hash]
+		if id == "" || seen[id] {
+			continue
+		}
+		ret = append(ret, key{Id: id, Key: m.IdKey})
+		seen[id] = true
+	}
+
+	return ret
+}
+
+func (m *AssocMediator) ModifyReq(req *ConnMsg) (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if req.ActionName == "change-public-keys" {
+		// a new session, it has to prove its associations again
+		delete(m.proven, req.ClientId)
+		return nil
+	}
+
+	switch d := req.data.(type) {
+	case *MsgAssociate:
+		if req.ActionName == "associate" {
+			return m.associate(req, d)
+		}
+		return m.testAssociate(req, d)
+	case *MsgGetLogins:
+		if d.Keys = m.keys(d.Keys); len(d.Keys) == 0 {
+			return NewProtocolError(ErrCodeAssociationFailed, "Association failed")
+		}
+	default:
+		if b, ok := req.data.(interface{ base() *MsgBase }); ok {
+			mb := b.base()
+			if va, ok := m.Clients[mb.Id]; ok && m.proven[req.ClientId][mb.Id] {
+				mb.Id = m.Upstream[va.Hash]
+			}
+		}
+	}
+
+	return nil
+}
+
+// upstreamLost reports whether the error code of a test-associate
+// response means KeePassXC no longer knows the MITM, other errors like a
+// locked database keep the association.
+func upstreamLost(code string) bool {
+	return code == fmt.Sprint(ErrCodeAssociationFailed) ||
+		code == fmt.Sprint(ErrCodeEncryptionKeyUnrecognized)
+}
+
+func (m *AssocMediator) ModifyRes(res *ConnMsg) (err error) {
+	req := res.Request()
+	if req == nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch res.ActionName {
+	case "associate":
+		idKey := m.release(req)
+		d, ok := res.data.(*MsgAssociate)
+		if !ok || !d.IsSuccess() || d.Id == "" || d.Hash == "" || idKey == "" {
+			return nil
+		}
+		m.Upstream[d.Hash] = d.Id
+		m.hashes[req.ClientId] = d.Hash
+		if d.Id, err = m.addClient(req.ClientId, idKey, d.Hash); err != nil {
+			delete(m.Upstream, d.Hash)
+			return err
+		}
+		m.prove(req.ClientId, d.Id)
+	case "test-associate":
+		id := m.release(req)
+		rd, ok := req.data.(*MsgAssociate)
+		if !ok {
+			return nil
+		}
+		// KeePassXC no longer knows the MITM, the next associate asks again
+		if res.ErrorCode != "" {
+			if !upstreamLost(res.ErrorCode) {
+				return nil
+			}
+			for hash, uid := range m.Upstream {
+				if uid == rd.Id {
+					delete(m.Upstream, hash)
+				}
+			}
+			return m.save()
+		}
+		if d, ok := res.data.(*MsgAssociate); ok && id != "" {
+			d.Id = id
+			m.prove(req.ClientId, id)
+		}
+	case "get-databasehash":
+		if d, ok := res.data.(*MsgGetDatabasehash); ok && d.Hash != "" {
+			m.hashes[req.ClientId] = d.Hash
+		}
+	}
+
+	return nil
+}
+
+// NewAssocMediator loads the mediator state from file, creating a new
+// identity if it does not exist yet. An empty file keeps the state in
+// memory only.
+func NewAssocMediator(file string, policy *Policy, logger LoggerI) (ret *AssocMediator, err error) {
+	ret = new(AssocMediator)
+	ret.file = file
+	ret.logger = logger
+	if ret.logger == nil {
+		ret.logger = nopLogger{}
+	}
+	if policy != nil {
+		if err = policy.compile(); err != nil {
+			return nil, err
+		}
+	}
+	ret.policy = policy
+	ret.hashes = make(map[string]string)
+	ret.pending = make(map[*ConnMsg]pendingReq)
+	ret.proven = make(map[string]map[string]bool)
+
+	if file != "" {
+		if err = ret.load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	if ret.Upstream == nil {
+		ret.Upstream = make(map[string]string)
+	}
+	if ret.Clients == nil {
+		ret.Clients = make(map[string]VirtualAssociation)
+	}
+	if ret.IdKey == "" {
+		if ret.IdKey, err = generateIdKey(); err != nil {
+			return nil, err
+		}
+		if err = ret.save(); err != nil {
+			return nil, err
+		}
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,146 @@
+package keepassxc_browser
+
+import (
+	"fmt"
+	"testing"
+)
+
+func mediatorReq(t *testing.T, action, clientId string) *ConnMsg {
+	t.Helper()
+	data, err := GetMessageType(action)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &ConnMsg{ActionName: action, ClientId: clientId, data: data}
+}
+
+func mediatorRes(req *ConnMsg, data MsgI, code int) *ConnMsg {
+	res := &ConnMsg{ActionName: req.ActionName, data: data, req: req}
+	if code != 0 {
+		res.ErrorCode = fmt.Sprint(code)
+	}
+	return res
+}
+
+// associated mediates the associations of two clients with the same client
+// id and returns their virtual ids.
+func associatedMediator(t *testing.T) (m *AssocMediator, ids []string) {
+	t.Helper()
+	m, err := NewAssocMediator("", &Policy{Default: PolicyAllow}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, idKey := range []string{"ka", "kb"} {
+		req := mediatorReq(t, "get-databasehash", "c")
+		if err = m.ModifyReq(req); err != nil {
+			t.Fatal(err)
+		}
+		if err = m.ModifyRes(mediatorRes(req, &MsgGetDatabasehash{MsgBase{Hash: "h"}}, 0)); err != nil {
+			t.Fatal(err)
+		}
+
+		req = mediatorReq(t, "associate", "c")
+		req.data.(*MsgAssociate).IdKey = idKey
+		err = m.ModifyReq(req)
+		if i > 0 {
+			// the MITM is associated already
+			reply, ok := err.(*MitmReply)
+			if !ok {
+				t.Fatalf("associate: got %v, want a reply", err)
+			}
+			ids = append(ids, reply.Data.(*MsgAssociate).Id)
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := &MsgAssociate{MsgBase: MsgBase{Id: "up", Hash: "h", Success: "true"}}
+		if err = m.ModifyRes(mediatorRes(req, res, 0)); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, res.Id)
+	}
+
+	return m, ids
+}
+
+func TestAssocMediatorTestAssociate(t *testing.T) {
+	m, ids := associatedMediator(t)
+
+	// both in flight, the answers come back in reverse order
+	var reqs []*ConnMsg
+	for i, idKey := range []string{"ka", "kb"} {
+		req := mediatorReq(t, "test-associate", "c")
+		d := req.data.(*MsgAssociate)
+		d.Id, d.Key = ids[i], idKey
+		if err := m.ModifyReq(req); err != nil {
+			t.Fatal(err)
+		}
+		if d.Id != "up" || d.Key != m.IdKey {
+			t.Fatalf("forwarded %s/%s, want the upstream association", d.Id, d.Key)
+		}
+		reqs = append(reqs, req)
+	}
+	for i := len(reqs) - 1; i >= 0; i-- {
+		res := &MsgAssociate{MsgBase: MsgBase{Id: "up", Hash: "h", Success: "true"}}
+		if err := m.ModifyRes(mediatorRes(reqs[i], res, 0)); err != nil {
+			t.Fatal(err)
+		}
+		if res.Id != ids[i] {
+			t.Errorf("client %d got id %s, want %s", i, res.Id, ids[i])
+		}
+	}
+	if len(m.pending) != 0 {
+		t.Errorf("%d requests still pending", len(m.pending))
+	}
+}
+
+func TestAssocMediatorUpstreamLost(t *testing.T) {
+	m, ids := associatedMediator(t)
+	testAssociate := func(code int) {
+		req := mediatorReq(t, "test-associate", "c")
+		d := req.data.(*MsgAssociate)
+		d.Id, d.Key = ids[0], "ka"
+		if err := m.ModifyReq(req); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.ModifyRes(mediatorRes(req, &MsgAssociate{}, code)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, code := range []int{ErrCodeDatabaseNotOpened, ErrCodeTimeoutOrNotConnected} {
+		if testAssociate(code); m.Upstream["h"] != "up" {
+			t.Fatalf("error %d dropped the upstream association", code)
+		}
+	}
+	if testAssociate(ErrCodeAssociationFailed); m.Upstream["h"] != "" {
+		t.Error("association failed kept the upstream association")
+	}
+}
+
+func TestAssocMediatorProvenIds(t *testing.T) {
+	m, ids := associatedMediator(t)
+	lock := func() string {
+		req := mediatorReq(t, "lock-database", "c")
+		d := req.data.(*MsgLockDatabase)
+		d.Id = ids[0]
+		if err := m.ModifyReq(req); err != nil {
+			t.Fatal(err)
+		}
+		return d.Id
+	}
+
+	// proven by the associate
+	if id := lock(); id != "up" {
+		t.Errorf("got id %s, want the upstream one", id)
+	}
+
+	// a new key exchange with the same client id has to prove it again
+	if err := m.ModifyReq(&ConnMsg{ActionName: "change-public-keys", ClientId: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if id := lock(); id != ids[0] {
+		t.Errorf("unproven virtual id mapped to %s", id)
+	}
+}
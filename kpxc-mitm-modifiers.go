@@ -0,0 +1,415 @@
+package keepassxc_browser
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MitmChain runs several modifiers, requests pass them in order and
+// responses in reverse order. The first error stops the chain.
+type MitmChain []KpXcMitmI
+
+func (c MitmChain) ModifyReq(req *ConnMsg) (err error) {
+	for _, m := range c {
+		if err = m.ModifyReq(req); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c MitmChain) ModifyRes(res *ConnMsg) (err error) {
+	for i := len(c) - 1; i >= 0; i-- {
+		if err = c[i].ModifyRes(res); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LogModifier logs every request and response passing the MITM without
+// their secrets. Logger is required, see NewLogModifier.
+type LogModifier struct {
+	Logger LoggerI
+}
+
+func NewLogModifier(logger LoggerI) (ret *LogModifier, err error) {
+	if logger == nil {
+		return nil, fmt.Errorf("Logger required")
+	}
+
+	return &LogModifier{Logger: logger}, nil
+}
+
+func (m *LogModifier) ModifyReq(req *ConnMsg) (err error) {
+	if m.Logger == nil {
+		return fmt.Errorf("LogModifier without Logger")
+	}
+	if u, ok := requestUrl(req); ok {
+		m.Logger.Printf("Request: client=%s action=%s requestID=%s url=%s\n",
+			req.ClientId, req.ActionName, req.RequestId, u)
+		return nil
+	}
+	m.Logger.Printf("Request: client=%s action=%s requestID=%s\n",
+		req.ClientId, req.ActionName, req.RequestId)
+
+	return nil
+}
+
+func (m *LogModifier) ModifyRes(res *ConnMsg) (err error) {
+	if m.Logger == nil {
+		return fmt.Errorf("LogModifier without Logger")
+	}
+	if res.Error != "" || res.ErrorCode != "" {
+		m.Logger.Printf("Response: action=%s error=%q code=%s\n", res.ActionName, res.Error, res.ErrorCode)
+		return nil
+	}
+	if d, ok := res.data.(*MsgGetLogins); ok {
+		uuids := make([]string, 0, len(d.Entries))
+		for _, e := range d.Entries {
+			uuids = append(uuids, e.Uuid)
+		}
+		m.Logger.Printf("Response: action=%s entries=%s\n", res.ActionName, strings.Join(uuids, ","))
+		return nil
+	}
+	m.Logger.Printf("Response: action=%s\n", res.ActionName)
+
+	return nil
+}
+
+// RedactModifier removes secrets from responses for the clients matching
+// Clients (all if empty).
+type RedactModifier struct {
+	Clients      []string
+	Passwords    bool
+	Totp         bool
+	StringFields bool
+	once         sync.Once
+	clients      []*regexp.Regexp
+	err          error
+}
+
+func (m *RedactModifier) ModifyReq(req *ConnMsg) (err error) {
+	return nil
+}
+
+func (m *RedactModifier) ModifyRes(res *ConnMsg) (err error) {
+	m.once.Do(func() {
+		m.clients, m.err = compileGlobs(m.Clients)
+	})
+	if m.err != nil {
+		return m.err
+	}
+	if req := res.Request(); req != nil && !matchAny(m.clients, req.ClientId) {
+		return nil
+	}
+
+	switch d := res.data.(type) {
+	case *MsgGetLogins:
+		for i := range d.Entries {
+			e := &d.Entries[i]
+			if m.Passwords {
+				e.Password.Wipe()
+				e.Password = Secret{}
+			}
+			if m.Totp {
+				stripTotpFields(e)
+			}
+			if m.StringFields {
+				e.StringFields = nil
+			}
+		}
+	case *MsgGeneratePassword:
+		if m.Passwords {
+			d.Password.Wipe()
+			d.Password = Secret{}
+		}
+	case *MsgGetTotp:
+		if m.Totp {
+			d.Totp.Wipe()
+			d.Totp = Secret{}
+		}
+	}
+
+	return nil
+}
+
+// UrlRewriteModifier maps internal host names to the URL the entries are
+// stored with before get-logins and set-login reach KeePassXC. Keys of
+// Hosts are host names, optionally with * wildcards, values the canonical
+// scheme://host[:port] replacing them. The path is kept.
+type UrlRewriteModifier struct {
+	Hosts map[string]string
+	once  sync.Once
+	rules []urlRewrite
+	err   error
+}
+
+type urlRewrite struct {
+	host   *regexp.Regexp
+	target *url.URL
+}
+
+// compile orders the rules longest host pattern first, so specific names
+// win over wildcards.
+func (m *UrlRewriteModifier) compile() (err error) {
+	hosts := make([]string, 0, len(m.Hosts))
+	for host := range m.Hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		if len(hosts[i]) != len(hosts[j]) {
+			return len(hosts[i]) > len(hosts[j])
+		}
+		return hosts[i] < hosts[j]
+	})
+
+	for _, host := range hosts {
+		target := m.Hosts[host]
+		res, err := compileGlobs([]string{host})
+		if err != nil {
+			return err
+		}
+		t := parseMatchUrl(target)
+		if t == nil {
+			return fmt.Errorf("Invalid rewrite target: %s", target)
+		}
+		m.rules = append(m.rules, urlRewrite{host: res[0], target: t})
+	}
+
+	return nil
+}
+
+// Rewrite returns raw with its scheme and host replaced, if a rule matches.
+func (m *UrlRewriteModifier) Rewrite(raw string) string {
+	u := parseMatchUrl(raw)
+	if u == nil {
+		return raw
+	}
+	for _, r := range m.rules {
+		if r.host.MatchString(u.Hostname()) {
+			u.Scheme = r.target.Scheme
+			u.Host = r.target.Host
+			return u.String()
+		}
+	}
+
+	return raw
+}
+
+func (m *UrlRewriteModifier) ModifyReq(req *ConnMsg) (err error) {
+	m.once.Do(func() {
+		m.err = m.compile()
+	})
+	if m.err != nil {
+		return m.err
+	}
+
+	switch d := req.data.(type) {
+	case *MsgGetLogins:
+		d.Url = m.Rewrite(d.Url)
+		if d.SubmitUrl != "" {
+			d.SubmitUrl = m.Rewrite(d.SubmitUrl)
+		}
+	case *MsgSetLogin:
+		d.Url = m.Rewrite(d.Url)
+		if d.SubmitUrl != "" {
+			d.SubmitUrl = m.Rewrite(d.SubmitUrl)
+		}
+	}
+
+	return nil
+}
+
+func (m *UrlRewriteModifier) ModifyRes(res *ConnMsg) (err error) {
+	return nil
+}
+
+// GroupFilterModifier drops get-logins entries by group. KeePassXC only
+// reports the name of the group of an entry, so Groups lists hold group
+// names. The Uuids lists only work with backends reporting the group UUID
+// too, like KdbxBackend, entries without one never match them. With a non
+// empty allow list only entries of the allowed groups pass, deny always
+// wins.
+type GroupFilterModifier struct {
+	AllowGroups []string
+	DenyGroups  []string
+	AllowUuids  []string
+	DenyUuids   []string
+}
+
+func groupListed(groups, uuids []string, e *LoginEntry) bool {
+	for _, g := range groups {
+		if e.Group != "" && e.Group == g {
+			return true
+		}
+	}
+	for _, u := range uuids {
+		if e.GroupUuid != "" && strings.EqualFold(e.GroupUuid, u) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *GroupFilterModifier) ModifyReq(req *ConnMsg) (err error) {
+	return nil
+}
+
+func (m *GroupFilterModifier) ModifyRes(res *ConnMsg) (err error) {
+	d, ok := res.data.(*MsgGetLogins)
+	if !ok {
+		return nil
+	}
+
+	entries := d.Entries[:0]
+	for i := range d.Entries {
+		e := &d.Entries[i]
+		allow := len(m.AllowGroups) == 0 && len(m.AllowUuids) == 0 ||
+			groupListed(m.AllowGroups, m.AllowUuids, e)
+		if !allow || groupListed(m.DenyGroups, m.DenyUuids, e) {
+			e.Wipe()
+			continue
+		}
+		entries = append(entries, *e)
+	}
+	d.Entries = entries
+	d.Count = len(entries)
+	if d.Count == 0 {
+		return NewProtocolError(ErrCodeNoLoginsFound, "No logins found")
+	}
+
+	return nil
+}
+
+// MaxRateBuckets bounds the clients a RateLimitModifier tracks, beyond it
+// new clients share one bucket.
+var MaxRateBuckets int = 4096
+
+// RateLimitModifier limits the requests per client with a token bucket of
+// Burst requests refilled at Rate requests per second. Actions restricts
+// the limit to these actions, all if empty. The buckets are kept per client
+// id, which the clients choose themselves, so it slows down well behaved
+// clients only: a client switching ids starts with a full bucket. Buckets
+// are dropped once refilled. Create it with NewRateLimitModifier.
+type RateLimitModifier struct {
+	Rate     float64
+	Burst    int
+	Actions  []string
+	mu       sync.Mutex
+	buckets  map[string]*rateBucket
+	overflow *rateBucket
+	now      func() time.Time
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimitModifier(rate float64, burst int, actions ...string) (ret *RateLimitModifier, err error) {
+	ret = &RateLimitModifier{Rate: rate, Burst: burst, Actions: actions}
+	if err = ret.check(); err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+func (m *RateLimitModifier) check() (err error) {
+	if !(m.Rate > 0) || math.IsInf(m.Rate, 1) {
+		return fmt.Errorf("Invalid rate: %v", m.Rate)
+	}
+	if m.Burst < 1 {
+		return fmt.Errorf("Invalid burst: %d", m.Burst)
+	}
+
+	return nil
+}
+
+func (m *RateLimitModifier) limited(action string) bool {
+	if len(m.Actions) == 0 {
+		return true
+	}
+	for _, a := range m.Actions {
+		if a == action {
+			return true
+		}
+	}
+
+	return false
+}
+
+// refill returns the tokens of b at now.
+func (m *RateLimitModifier) refill(b *rateBucket, now time.Time) float64 {
+	return math.Min(b.tokens+now.Sub(b.last).Seconds()*m.Rate, float64(m.Burst))
+}
+
+// bucket returns the bucket of clientId, the caller holds mu.
+func (m *RateLimitModifier) bucket(clientId string, now time.Time) (ret *rateBucket) {
+	if m.buckets == nil {
+		m.buckets = make(map[string]*rateBucket)
+	}
+	if ret, ok := m.buckets[clientId]; ok {
+		return ret
+	}
+
+	// a full bucket is the same as none
+	if len(m.buckets) >= MaxRateBuckets {
+		for id, b := range m.buckets {
+			if m.refill(b, now) >= float64(m.Burst) {
+				delete(m.buckets, id)
+			}
+		}
+	}
+	if len(m.buckets) >= MaxRateBuckets {
+		if m.overflow == nil {
+			m.overflow = &rateBucket{tokens: float64(m.Burst), last: now}
+		}
+		return m.overflow
+	}
+	ret = &rateBucket{tokens: float64(m.Burst), last: now}
+	m.buckets[clientId] = ret
+
+	return ret
+}
+
+func (m *RateLimitModifier) ModifyReq(req *ConnMsg) (err error) {
+	if !m.limited(req.ActionName) {
+		return nil
+	}
+	if err = m.check(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.now == nil {
+		m.now = time.Now
+	}
+
+	now := m.now()
+	b := m.bucket(req.ClientId, now)
+	b.tokens = m.refill(b, now)
+	b.last = now
+
+	if b.tokens < 1 {
+		return NewProtocolError(ErrCodeActionCancelledOrDenied, "Rate limit exceeded")
+	}
+	b.tokens--
+
+	return nil
+}
+
+func (m *RateLimitModifier) ModifyRes(res *ConnMsg) (err error) {
+	return nil
+}
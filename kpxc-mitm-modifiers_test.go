@@ -0,0 +1,93 @@
+package keepassxc_browser
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimitModifierConfig(t *testing.T) {
+	for _, tt := range []struct {
+		rate  float64
+		burst int
+	}{{0, 1}, {-1, 1}, {1, 0}} {
+		if _, err := NewRateLimitModifier(tt.rate, tt.burst); err == nil {
+			t.Errorf("rate %v burst %d accepted", tt.rate, tt.burst)
+		}
+	}
+	if err := (&RateLimitModifier{Rate: 1}).ModifyReq(&ConnMsg{ClientId: "a"}); err == nil {
+		t.Error("zero Burst accepted")
+	}
+}
+
+func TestRateLimitModifierBuckets(t *testing.T) {
+	defer func(max int) { MaxRateBuckets = max }(MaxRateBuckets)
+	MaxRateBuckets = 2
+
+	m, err := NewRateLimitModifier(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(0, 0)
+	m.now = func() time.Time { return now }
+	req := func(id string) error { return m.ModifyReq(&ConnMsg{ClientId: id}) }
+
+	if err = req("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err = req("a"); err == nil {
+		t.Error("second request within the burst passed")
+	}
+	if err = req("b"); err != nil {
+		t.Fatal(err)
+	}
+	// the map is full, new clients share one bucket
+	if err = req("c"); err != nil {
+		t.Fatal(err)
+	}
+	if err = req("d"); err == nil {
+		t.Error("overflow bucket not shared")
+	}
+
+	// refilled buckets are dropped
+	now = now.Add(2 * time.Second)
+	if err = req("e"); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.buckets) != 1 {
+		t.Errorf("%d buckets left, want 1", len(m.buckets))
+	}
+}
+
+func TestGroupFilterModifier(t *testing.T) {
+	entries := []LoginEntry{
+		{Uuid: "1", Group: "Web"},
+		{Uuid: "2", Group: "Web", GroupUuid: "AA"},
+		{Uuid: "3", Group: "Bank", GroupUuid: "BB"},
+	}
+	tests := []struct {
+		m    GroupFilterModifier
+		want string
+	}{
+		{GroupFilterModifier{AllowGroups: []string{"Web"}}, "12"},
+		{GroupFilterModifier{DenyGroups: []string{"Web"}}, "3"},
+		// UUIDs never match entries without one
+		{GroupFilterModifier{AllowUuids: []string{"aa"}}, "2"},
+		{GroupFilterModifier{DenyUuids: []string{"AA"}}, "13"},
+		{GroupFilterModifier{AllowGroups: []string{"Web"}, DenyUuids: []string{"AA"}}, "1"},
+		// a UUID is no group name
+		{GroupFilterModifier{AllowGroups: []string{"AA"}}, ""},
+	}
+	for i, tt := range tests {
+		res := &ConnMsg{ActionName: "get-logins", data: &MsgGetLogins{Entries: append([]LoginEntry(nil), entries...)}}
+		if err := tt.m.ModifyRes(res); err != nil && tt.want != "" {
+			t.Fatal(err)
+		}
+		got := ""
+		for _, e := range res.data.(*MsgGetLogins).Entries {
+			got += e.Uuid
+		}
+		if got != tt.want {
+			t.Errorf("%d: got entries %q, want %q", i, got, tt.want)
+		}
+	}
+}
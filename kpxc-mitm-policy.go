@@ -0,0 +1,275 @@
+package keepassxc_browser
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+const PolicyAllow string = "allow"
+const PolicyDeny string = "deny"
+
+// PolicyRule matches requests by client id, action and URL. Empty lists
+// match everything, patterns may contain * wildcards. URL patterns are
+// matched against the url of get-logins and set-login requests, a rule with
+// URL patterns never matches other actions.
+type PolicyRule struct {
+	Name          string   `yaml:"name" json:"name"`
+	Clients       []string `yaml:"clients" json:"clients"`
+	Actions       []string `yaml:"actions" json:"actions"`
+	Urls          []string `yaml:"urls" json:"urls"`
+	Effect        string   `yaml:"effect" json:"effect"`
+	StripPassword bool     `yaml:"stripPassword" json:"stripPassword"`
+	StripTotp     bool     `yaml:"stripTotp" json:"stripTotp"`
+	clients       []*regexp.Regexp
+	actions       []*regexp.Regexp
+	urls          []*regexp.Regexp
+}
+
+// Policy is evaluated firewall style, the first matching rule decides.
+// Without a matching rule Default applies.
+type Policy struct {
+	Default string       `yaml:"default" json:"default"`
+	Rules   []PolicyRule `yaml:"rules" json:"rules"`
+}
+
+// PolicyModifier is a KpXcMitmI enforcing a Policy. Denied requests are
+// answered with ErrCodeActionCancelledOrDenied and never reach KeePassXC.
+type PolicyModifier struct {
+	mu     sync.RWMutex
+	policy *Policy
+	logger LoggerI
+}
+
+func compileGlobs(patterns []string) (ret []*regexp.Regexp, err error) {
+	for _, p := range patterns {
+		expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(p), `\*`, ".*") + "$"
+		re, err := regexp.Compile("(?i)" + expr)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, re)
+	}
+
+	return ret, nil
+}
+
+func matchAny(res []*regexp.Regexp, s string) bool {
+	if len(res) == 0 {
+		return true
+	}
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *Policy) compile() (err error) {
+	switch p.Default {
+	case "":
+		p.Default = PolicyDeny
+	case PolicyAllow, PolicyDeny:
+	default:
+		return fmt.Errorf("Invalid default effect: %s", p.Default)
+	}
+
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		switch r.Effect {
+		case "":
+			r.Effect = PolicyAllow
+		case PolicyAllow, PolicyDeny:
+		default:
+			return fmt.Errorf("Invalid effect in rule %d: %s", i, r.Effect)
+		}
+		if r.clients, err = compileGlobs(r.Clients); err != nil {
+			return err
+		}
+		if r.actions, err = compileGlobs(r.Actions); err != nil {
+			return err
+		}
+		if r.urls, err = compileGlobs(r.Urls); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func requestUrl(req *ConnMsg) (ret string, ok bool) {
+	switch d := req.data.(type) {
+	case *MsgGetLogins:
+		return d.Url, true
+	case *MsgSetLogin:
+		return d.Url, true
+	}
+
+	return "", false
+}
+
+func (r *PolicyRule) matches(req *ConnMsg) bool {
+	if !matchAny(r.clients, req.ClientId) || !matchAny(r.actions, req.ActionName) {
+		return false
+	}
+	if len(r.urls) > 0 {
+		u, ok := requestUrl(req)
+		if !ok || !matchAny(r.urls, u) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Effect returns whether req is allowed and the name of the deciding rule.
+func (p *Policy) Effect(req *ConnMsg) (effect, name string) {
+	if r := p.Match(req); r != nil {
+		return r.Effect, r.Name
+	}
+
+	return p.Default, "default"
+}
+
+// Match returns the rule deciding about req, nil if the default applies.
+func (p *Policy) Match(req *ConnMsg) *PolicyRule {
+	for i := range p.Rules {
+		if p.Rules[i].matches(req) {
+			return &p.Rules[i]
+		}
+	}
+
+	return nil
+}
+
+func ParsePolicy(data []byte) (ret *Policy, err error) {
+	// YAML is a superset of JSON, so this reads both
+	ret = new(Policy)
+	if err = yaml.Unmarshal(data, ret); err != nil {
+		return nil, err
+	}
+	if err = ret.compile(); err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+func LoadPolicy(file string) (ret *Policy, err error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParsePolicy(data)
+}
+
+func (m *PolicyModifier) current() *Policy {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.policy
+}
+
+// SetPolicy replaces the policy, e.g. after the configuration was reloaded.
+func (m *PolicyModifier) SetPolicy(policy *Policy) (err error) {
+	if err = policy.compile(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.policy = policy
+	m.mu.Unlock()
+
+	return nil
+}
+
+func (m *PolicyModifier) ModifyReq(req *ConnMsg) (err error) {
+	effect, name := m.current().Effect(req)
+	if effect == PolicyDeny {
+		m.logger.Printf("Denied %s for client %s by rule %s\n", req.ActionName, req.ClientId, name)
+		return NewProtocolError(ErrCodeActionCancelledOrDenied, "Action cancelled or denied")
+	}
+
+	return nil
+}
+
+// totpFields are the attributes KeePassXC keeps TOTP settings in, string
+// fields carry them with the "KPH: " prefix.
+var totpFields = map[string]bool{
+	"otp":           true,
+	"totp seed":     true,
+	"totp settings": true,
+}
+
+func isTotpField(key string) bool {
+	key = strings.TrimPrefix(key, "KPH: ")
+	return totpFields[strings.ToLower(strings.TrimSpace(key))]
+}
+
+// stripTotpFields removes the TOTP code and the TOTP settings of e.
+func stripTotpFields(e *LoginEntry) {
+	e.Totp.Wipe()
+	e.Totp = nil
+	var fields []map[string]string
+	for _, f := range e.StringFields {
+		keep := true
+		for k := range f {
+			if isTotpField(k) {
+				keep = false
+			}
+		}
+		if keep {
+			fields = append(fields, f)
+		}
+	}
+	e.StringFields = fields
+}
+
+func (m *PolicyModifier) ModifyRes(res *ConnMsg) (err error) {
+	req := res.Request()
+	if req == nil {
+		return nil
+	}
+	r := m.current().Match(req)
+	if r == nil {
+		return nil
+	}
+
+	switch d := res.data.(type) {
+	case *MsgGetLogins:
+		for i := range d.Entries {
+			if r.StripPassword {
+				d.Entries[i].Password.Wipe()
+				d.Entries[i].Password = Secret{}
+			}
+			if r.StripTotp {
+				stripTotpFields(&d.Entries[i])
+			}
+		}
+	case *MsgGetTotp:
+		if r.StripTotp {
+			d.Totp.Wipe()
+			d.Totp = Secret{}
+		}
+	}
+
+	return nil
+}
+
+func NewPolicyModifier(policy *Policy, logger LoggerI) (ret *PolicyModifier, err error) {
+	ret = new(PolicyModifier)
+	ret.logger = logger
+	if ret.logger == nil {
+		ret.logger = nopLogger{}
+	}
+	if err = ret.SetPolicy(policy); err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,134 @@
+package keepassxc_browser
+
+import (
+	"errors"
+	"testing"
+)
+
+func policyReq(t *testing.T, action, clientId, url string) *ConnMsg {
+	t.Helper()
+	req, err := GenerateConnReq(action, clientId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch d := req.data.(type) {
+	case *MsgGetLogins:
+		d.Url = url
+	case *MsgSetLogin:
+		d.Url = url
+	}
+
+	return req
+}
+
+func TestPolicyEffect(t *testing.T) {
+	policy, err := ParsePolicy([]byte(`
+rules:
+  - name: no-bank
+    urls: ["https://*.bank.example"]
+    effect: deny
+  - name: firefox-logins
+    clients: ["firefox-*"]
+    actions: ["get-logins", "set-login"]
+  - name: hash
+    actions: ["get-databasehash"]
+  - name: late
+    clients: ["firefox-*"]
+    effect: deny
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		action, client, url string
+		effect, rule        string
+	}{
+		// first match wins
+		{"get-logins", "firefox-1", "https://www.bank.example", PolicyDeny, "no-bank"},
+		{"get-logins", "firefox-1", "https://example.com", PolicyAllow, "firefox-logins"},
+		{"get-databasehash", "firefox-1", "", PolicyAllow, "hash"},
+		{"get-totp", "firefox-1", "", PolicyDeny, "late"},
+		// client globs are anchored
+		{"get-logins", "chrome-firefox-1", "https://example.com", PolicyDeny, "default"},
+		// URL rules never match actions without a URL
+		{"get-totp", "chrome", "", PolicyDeny, "default"},
+		// default deny
+		{"lock-database", "chrome", "", PolicyDeny, "default"},
+	}
+	for _, tt := range tests {
+		effect, rule := policy.Effect(policyReq(t, tt.action, tt.client, tt.url))
+		if effect != tt.effect || rule != tt.rule {
+			t.Errorf("%s %s %s: got %s by %s, want %s by %s", tt.client, tt.action, tt.url, effect, rule, tt.effect, tt.rule)
+		}
+	}
+
+	for _, data := range []string{`default: maybe`, `rules: [{effect: perhaps}]`} {
+		if _, err = ParsePolicy([]byte(data)); err == nil {
+			t.Errorf("%s accepted", data)
+		}
+	}
+}
+
+func TestPolicyModifierDeny(t *testing.T) {
+	m, err := NewPolicyModifier(&Policy{Default: PolicyAllow, Rules: []PolicyRule{
+		{Clients: []string{"evil"}, Effect: PolicyDeny},
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var perr *ProtocolError
+	if err = m.ModifyReq(policyReq(t, "get-logins", "evil", "https://example.com")); !errors.As(err, &perr) || perr.Code != ErrCodeActionCancelledOrDenied {
+		t.Errorf("got %v, want denied", err)
+	}
+	if err = m.ModifyReq(policyReq(t, "get-logins", "good", "https://example.com")); err != nil {
+		t.Errorf("default allow: %v", err)
+	}
+}
+
+func TestPolicyModifierStrip(t *testing.T) {
+	m, err := NewPolicyModifier(&Policy{Rules: []PolicyRule{
+		{Clients: []string{"cli"}, StripPassword: true},
+		{Clients: []string{"browser"}, StripTotp: true},
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := func(client string) *LoginEntry {
+		t.Helper()
+		r, _ := GenerateConnReq("get-logins", client)
+		r.req = policyReq(t, "get-logins", client, "https://example.com")
+		r.data = &MsgGetLogins{Entries: []LoginEntry{{
+			Password: Secret("pw"),
+			Totp:     Secret("123456"),
+			StringFields: []map[string]string{
+				{"KPH: otp": "otpauth://totp/x?secret=ABC"},
+				{"KPH: TOTP Seed": "ABC"},
+				{"KPH: footprint": "left"},
+				{"KPH: username2": "bob"},
+			},
+		}}}
+		if err := m.ModifyRes(r); err != nil {
+			t.Fatal(err)
+		}
+		return &r.data.(*MsgGetLogins).Entries[0]
+	}
+
+	e := res("cli")
+	if len(e.Password) != 0 || string(e.Totp) != "123456" || len(e.StringFields) != 4 {
+		t.Errorf("password stripped: got %+v", e)
+	}
+	e = res("browser")
+	if string(e.Password) != "pw" || len(e.Totp) != 0 {
+		t.Errorf("TOTP stripped: got %+v", e)
+	}
+	var keys []string
+	for _, f := range e.StringFields {
+		for k := range f {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) != 2 || keys[0] != "KPH: footprint" || keys[1] != "KPH: username2" {
+		t.Errorf("got fields %v, want the non TOTP ones", keys)
+	}
+}
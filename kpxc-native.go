@@ -0,0 +1,149 @@
+package keepassxc_browser
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const NativeHostName string = "org.keepassxc.keepassxc_browser"
+const NativeHostDescription string = "KeePassXC integration with native messaging support"
+
+const FirefoxExtensionId string = "keepassxc-browser@keepassxc.org"
+
+var ChromiumExtensionOrigins = []string{
+	"chrome-extension://pdffhmdngciaglkoonimfcmckehcpafo/",
+	"chrome-extension://oboonakemofpalcgghocfoadofidjkkk/",
+}
+
+// NativeArgs are the arguments a browser passes to a native messaging host.
+type NativeArgs struct {
+	// ExtensionId is the Firefox extension id or the chrome-extension://
+	// origin of the calling extension.
+	ExtensionId  string
+	ManifestPath string
+	ParentWindow string
+}
+
+// ParseNativeArgs parses the command line a browser starts the host with.
+// Firefox passes the manifest path and the extension id, Chromium passes the
+// origin of the extension and on Windows --parent-window=<handle>.
+func ParseNativeArgs(args []string) (ret NativeArgs) {
+	for _, a := range args {
+		switch {
+		case strings.HasPrefix(a, "chrome-extension://"):
+			ret.ExtensionId = a
+		case strings.HasPrefix(a, "--parent-window="):
+			ret.ParentWindow = strings.TrimPrefix(a, "--parent-window=")
+		case strings.HasSuffix(a, ".json"):
+			ret.ManifestPath = a
+		case a != "" && !strings.HasPrefix(a, "-"):
+			ret.ExtensionId = a
+		}
+	}
+
+	return ret
+}
+
+// KnownExtension reports if the caller is the official KeePassXC-Browser
+// extension, see FirefoxExtensionId and ChromiumExtensionOrigins, or one of
+// extra, e.g. a development build.
+func (a NativeArgs) KnownExtension(extra ...string) bool {
+	if a.ExtensionId == "" {
+		return false
+	}
+	if a.ExtensionId == FirefoxExtensionId {
+		return true
+	}
+	for _, ids := range [][]string{ChromiumExtensionOrigins, extra} {
+		for _, id := range ids {
+			if a.ExtensionId == id {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+type nativeManifest struct {
+	Name              string   `json:"name"`
+	Description       string   `json:"description"`
+	Path              string   `json:"path"`
+	Type              string   `json:"type"`
+	AllowedExtensions []string `json:"allowed_extensions,omitempty"`
+	AllowedOrigins    []string `json:"allowed_origins,omitempty"`
+}
+
+func nativeManifestDirs(home string) map[string]string {
+	return map[string]string{
+		"firefox":   filepath.Join(home, ".mozilla", "native-messaging-hosts"),
+		"librewolf": filepath.Join(home, ".librewolf", "native-messaging-hosts"),
+		"chrome":    filepath.Join(home, ".config", "google-chrome", "NativeMessagingHosts"),
+		"chromium":  filepath.Join(home, ".config", "chromium", "NativeMessagingHosts"),
+		"brave":     filepath.Join(home, ".config", "BraveSoftware", "Brave-Browser", "NativeMessagingHosts"),
+		"vivaldi":   filepath.Join(home, ".config", "vivaldi", "NativeMessagingHosts"),
+		"edge":      filepath.Join(home, ".config", "microsoft-edge", "NativeMessagingHosts"),
+	}
+}
+
+func isFirefoxBased(browser string) bool {
+	return browser == "firefox" || browser == "librewolf"
+}
+
+// NativeManifest returns the native messaging host manifest for browser
+// pointing to the host binary at hostPath.
+func NativeManifest(browser, hostPath string) (ret []byte, err error) {
+	if _, ok := nativeManifestDirs("")[browser]; !ok {
+		return nil, fmt.Errorf("Unknown browser: %s", browser)
+	}
+	if !filepath.IsAbs(hostPath) {
+		return nil, fmt.Errorf("Host path must be absolute: %s", hostPath)
+	}
+
+	m := nativeManifest{
+		Name:        NativeHostName,
+		Description: NativeHostDescription,
+		Path:        hostPath,
+		Type:        "stdio",
+	}
+	if isFirefoxBased(browser) {
+		m.AllowedExtensions = []string{FirefoxExtensionId}
+	} else {
+		m.AllowedOrigins = ChromiumExtensionOrigins
+	}
+
+	return json.MarshalIndent(m, "", "    ")
+}
+
+// NativeManifestPath returns where browser looks for the user's manifest.
+func NativeManifestPath(browser string) (ret string, err error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir, ok := nativeManifestDirs(home)[browser]
+	if !ok {
+		return "", fmt.Errorf("Unknown browser: %s", browser)
+	}
+
+	return filepath.Join(dir, NativeHostName+".json"), nil
+}
+
+// InstallNativeManifest writes the manifest for browser and returns its path.
+func InstallNativeManifest(browser, hostPath string) (ret string, err error) {
+	manifest, err := NativeManifest(browser, hostPath)
+	if err != nil {
+		return "", err
+	}
+	if ret, err = NativeManifestPath(browser); err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(filepath.Dir(ret), 0755); err != nil {
+		return "", err
+	}
+
+	return ret, os.WriteFile(ret, manifest, 0644)
+}
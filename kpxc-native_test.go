@@ -0,0 +1,46 @@
+package keepassxc_browser
+
+import "testing"
+
+func TestParseNativeArgs(t *testing.T) {
+	tests := []struct {
+		args  []string
+		want  NativeArgs
+		known bool
+	}{
+		// Firefox
+		{
+			[]string{"/usr/lib/mozilla/native-messaging-hosts/org.keepassxc.keepassxc_browser.json", FirefoxExtensionId},
+			NativeArgs{ExtensionId: FirefoxExtensionId, ManifestPath: "/usr/lib/mozilla/native-messaging-hosts/org.keepassxc.keepassxc_browser.json"},
+			true,
+		},
+		// Chromium, on Windows with the parent window
+		{
+			[]string{ChromiumExtensionOrigins[0], "--parent-window=42"},
+			NativeArgs{ExtensionId: ChromiumExtensionOrigins[0], ParentWindow: "42"},
+			true,
+		},
+		{
+			[]string{"chrome-extension://aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa/"},
+			NativeArgs{ExtensionId: "chrome-extension://aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa/"},
+			false,
+		},
+		// started by hand
+		{nil, NativeArgs{}, false},
+		{[]string{"-install", ""}, NativeArgs{}, false},
+	}
+	for _, tt := range tests {
+		got := ParseNativeArgs(tt.args)
+		if got != tt.want {
+			t.Errorf("ParseNativeArgs(%q) = %+v, want %+v", tt.args, got, tt.want)
+		}
+		if known := got.KnownExtension(); known != tt.known {
+			t.Errorf("%q: KnownExtension() = %v, want %v", tt.args, known, tt.known)
+		}
+	}
+
+	dev := ParseNativeArgs([]string{"dev@example.com"})
+	if !dev.KnownExtension("dev@example.com") {
+		t.Error("extra extension id not accepted")
+	}
+}
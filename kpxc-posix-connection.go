@@ -11,6 +11,10 @@ type PosixConnection struct {
 }
 
 func (conn *PosixConnection) Connect(address string) (err error) {
+	// already connected, e.g. accepted by a SocketServer
+	if address == "" && conn.c != nil {
+		return nil
+	}
 	if conn.c, err = net.DialUnix("unix", nil,
 		&net.UnixAddr{Name: address, Net: "unix"}); err != nil {
 		return err
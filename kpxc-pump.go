@@ -0,0 +1,184 @@
+package keepassxc_browser
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// NotifyBufSize is the number of unsolicited messages queued for a client
+// before further ones are dropped.
+const NotifyBufSize int = 16
+
+// NotificationActions are the actions KeePassXC sends without a request.
+var NotificationActions = []string{"database-locked", "database-unlocked"}
+
+// NotifierI is implemented by handlers relaying a connection which carries
+// messages without a request. Server forwards them to its client.
+type NotifierI interface {
+	Notifications() <-chan []byte
+}
+
+// IsNotification reports whether msg was sent by KeePassXC on its own
+// instead of answering a request. Empty objects, which KeePassXC sends now
+// and then, count as notifications as well.
+func IsNotification(msg []byte) bool {
+	var m struct {
+		ActionName string `json:"action"`
+	}
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return false
+	}
+	if m.ActionName == "" {
+		return len(msg) <= 2
+	}
+	for _, a := range NotificationActions {
+		if m.ActionName == a {
+			return true
+		}
+	}
+
+	return false
+}
+
+// upstreamPump reads the connection to KeePassXC in the background. Answers
+// go to the pending Exchange, notifications to the notify channel, so they
+// are neither paired with the wrong request nor lost.
+type upstreamPump struct {
+	conn   ConnectionI
+	mu     sync.Mutex
+	res    chan []byte
+	notify chan<- []byte
+	done   chan struct{}
+	err    error
+}
+
+func (p *upstreamPump) run() {
+	defer close(p.done)
+	for {
+		msg, err := p.conn.Recv(BufSize, 0)
+		if err != nil {
+			p.err = err
+			return
+		}
+		if !IsNotification(msg) {
+			select {
+			case p.res <- msg:
+			default:
+				// nobody took the older answer, keep the newer one as
+				// the older one answers an abandoned request or the
+				// newer one nothing at all
+				select {
+				case <-p.res:
+				default:
+				}
+				p.res <- msg
+			}
+			continue
+		}
+		select {
+		case p.notify <- msg:
+		default:
+			// nobody is listening, notifications are informational only
+		}
+	}
+}
+
+// pumpMsg holds the fields pairing an answer with its request.
+type pumpMsg struct {
+	ActionName string `json:"action"`
+	Nonce      string `json:"nonce"`
+	RequestId  string `json:"requestID"`
+}
+
+// answers reports whether res answers req. The request ids have to match
+// if both carry one, else the nonce of res has to be the incremented nonce
+// of req. Error replies carry neither, they are paired by action.
+func (req *pumpMsg) answers(res *pumpMsg) bool {
+	if req.RequestId != "" && res.RequestId != "" {
+		return req.RequestId == res.RequestId
+	}
+	if res.Nonce != "" {
+		nonce, err := base64.StdEncoding.DecodeString(req.Nonce)
+		if err != nil || len(nonce) != BoxNonceSize {
+			return false
+		}
+		n := BoxNonce{Bytes: nonce}
+		n.Next()
+		return res.Nonce == base64.StdEncoding.EncodeToString(n.Bytes)
+	}
+
+	return res.ActionName == "" || res.ActionName == req.ActionName
+}
+
+// Exchange sends req and waits up to timeout for its answer, answers to
+// other requests are dropped. Without an answer in time it returns an
+// ErrCodeTimeoutOrNotConnected *ProtocolError and the connection stays
+// usable.
+func (p *upstreamPump) Exchange(req []byte, timeout time.Duration) (ret []byte, err error) {
+	var preq pumpMsg
+	if err = json.Unmarshal(req, &preq); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// answers of abandoned requests must not be taken for this one
+	for len(p.res) > 0 {
+		<-p.res
+	}
+
+	if err = p.conn.Send(req); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case ret = <-p.res:
+			var pres pumpMsg
+			if json.Unmarshal(ret, &pres) == nil && preq.answers(&pres) {
+				return ret, nil
+			}
+			// a late answer to an abandoned request
+		case <-timer.C:
+			return nil, errTimeout()
+		case <-p.done:
+			if p.err == nil {
+				return nil, fmt.Errorf("Connection closed")
+			}
+			return nil, p.err
+		}
+	}
+}
+
+// closed reports whether the connection is gone.
+func (p *upstreamPump) closed() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close closes the connection, which ends the background reader.
+func (p *upstreamPump) Close() {
+	p.conn.Close()
+}
+
+func newUpstreamPump(conn ConnectionI, notify chan<- []byte) *upstreamPump {
+	p := &upstreamPump{
+		conn:   conn,
+		res:    make(chan []byte, 1),
+		notify: notify,
+		done:   make(chan struct{}),
+	}
+	go p.run()
+
+	return p
+}
@@ -0,0 +1,109 @@
+package keepassxc_browser
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scriptConn answers every message with the messages reply returns.
+type scriptConn struct {
+	recv  chan []byte
+	reply func(msg []byte) []string
+	once  sync.Once
+}
+
+func (c *scriptConn) Connect(address string) error { return nil }
+func (c *scriptConn) Close()                       { c.once.Do(func() { close(c.recv) }) }
+
+func (c *scriptConn) Send(msg []byte) error {
+	for _, r := range c.reply(msg) {
+		c.recv <- []byte(r)
+	}
+	return nil
+}
+
+func (c *scriptConn) Recv(bufsize int, timeout int) ([]byte, error) {
+	msg, ok := <-c.recv
+	if !ok {
+		return nil, fmt.Errorf("Connection closed")
+	}
+	return msg, nil
+}
+
+func TestUpstreamPumpExchange(t *testing.T) {
+	nonce := BoxNonce{Bytes: make([]byte, BoxNonceSize)}
+	req := fmt.Sprintf(`{"action":"get-logins","nonce":"%s"}`, base64.StdEncoding.EncodeToString(nonce.Bytes))
+	nonce.Next()
+	next := base64.StdEncoding.EncodeToString(nonce.Bytes)
+
+	tests := []struct {
+		req     string
+		replies []string
+		want    string
+	}{
+		{
+			`{"action":"get-logins","requestID":"2"}`,
+			[]string{`{"action":"get-logins","requestID":"1"}`, `{"action":"get-logins","requestID":"2"}`},
+			`{"action":"get-logins","requestID":"2"}`,
+		},
+		{
+			req,
+			[]string{`{"action":"get-logins","nonce":"` + base64.StdEncoding.EncodeToString(make([]byte, BoxNonceSize)) + `"}`,
+				`{"action":"get-logins","nonce":"` + next + `"}`},
+			`{"action":"get-logins","nonce":"` + next + `"}`,
+		},
+		// error replies lack both
+		{
+			req,
+			[]string{`{"action":"get-totp","errorCode":"1"}`, `{"action":"get-logins","errorCode":"15"}`},
+			`{"action":"get-logins","errorCode":"15"}`,
+		},
+	}
+	for i, tt := range tests {
+		replies := tt.replies
+		conn := &scriptConn{recv: make(chan []byte, len(replies)), reply: func([]byte) []string { return replies }}
+		p := newUpstreamPump(conn, make(chan []byte, 1))
+		ret, err := p.Exchange([]byte(tt.req), 5*time.Second)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if string(ret) != tt.want {
+			t.Errorf("%d: got %s, want %s", i, ret, tt.want)
+		}
+		p.Close()
+	}
+}
+
+func TestUpstreamPumpTimeout(t *testing.T) {
+	answer := false
+	conn := &scriptConn{recv: make(chan []byte, 1), reply: func(msg []byte) []string {
+		// the first request is never answered
+		if !answer {
+			answer = true
+			return nil
+		}
+		return []string{string(msg)}
+	}}
+	p := newUpstreamPump(conn, make(chan []byte, 1))
+	defer p.Close()
+
+	_, err := p.Exchange([]byte(`{"action":"get-logins","requestID":"1"}`), 100*time.Millisecond)
+	var perr *ProtocolError
+	if !errors.As(err, &perr) || perr.Code != ErrCodeTimeoutOrNotConnected {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+
+	// the connection is still usable
+	req := `{"action":"get-logins","requestID":"2"}`
+	ret, err := p.Exchange([]byte(req), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(ret) != req {
+		t.Errorf("got %s, want %s", ret, req)
+	}
+}
@@ -0,0 +1,314 @@
+package keepassxc_browser
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RefScheme is the scheme of secret references:
+//
+//	kpxc://[login@]host[:port][/path]/field[?scheme=http&login=name]
+//
+// The entry URL is https://host[:port][/path] unless another scheme is
+// given. Without a login the best match of KeePassXC is used. The field is
+// password, login, totp or a custom attribute KPH:name, e.g.
+// kpxc://admin@db.internal/password or kpxc://ci.internal/KPH:token.
+const RefScheme string = "kpxc"
+
+const (
+	RefFieldPassword string = "password"
+	RefFieldLogin    string = "login"
+	RefFieldTotp     string = "totp"
+	// RefFieldAttr prefixes the name of a custom attribute, KeePassXC only
+	// hands out attributes named "KPH: name".
+	RefFieldAttr string = "KPH:"
+)
+
+// DefaultResolverCacheTTL is how long a Resolver keeps the entries of an
+// URL, TOTPs are never cached.
+var DefaultResolverCacheTTL = time.Minute
+
+// SecretRef is a parsed kpxc:// reference.
+type SecretRef struct {
+	Url   string
+	Login string
+	Field string
+}
+
+// ParseRef parses a reference of the RefScheme syntax.
+func ParseRef(ref string) (ret *SecretRef, err error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != RefScheme || u.Host == "" {
+		return nil, fmt.Errorf("Invalid secret reference: %s", ref)
+	}
+	i := strings.LastIndex(u.Path, "/")
+	if i < 0 || i == len(u.Path)-1 {
+		return nil, fmt.Errorf("No field in secret reference: %s", ref)
+	}
+	ret = &SecretRef{Login: u.Query().Get("login"), Field: u.Path[i+1:]}
+	if u.User != nil {
+		ret.Login = u.User.Username()
+	}
+
+	switch ret.Field {
+	case RefFieldPassword, RefFieldLogin, RefFieldTotp:
+	default:
+		name := strings.TrimSpace(strings.TrimPrefix(ret.Field, RefFieldAttr))
+		if !strings.HasPrefix(ret.Field, RefFieldAttr) || name == "" {
+			return nil, fmt.Errorf("Invalid field %q in secret reference: %s", ret.Field, ref)
+		}
+		ret.Field = RefFieldAttr + name
+	}
+
+	scheme := u.Query().Get("scheme")
+	if scheme == "" {
+		scheme = "https"
+	}
+	ret.Url = scheme + "://" + u.Host + u.Path[:i]
+
+	return ret, nil
+}
+
+func (r *SecretRef) String() string {
+	u, err := url.Parse(r.Url)
+	if err != nil {
+		return ""
+	}
+	ret := url.URL{Scheme: RefScheme, Host: u.Host, Path: u.Path + "/" + r.Field}
+	if r.Login != "" {
+		ret.User = url.User(r.Login)
+	}
+	if u.Scheme != "https" {
+		ret.RawQuery = url.Values{"scheme": {u.Scheme}}.Encode()
+	}
+
+	return ret.String()
+}
+
+// attr returns the value of the custom attribute name, KeePassXC names
+// them "KPH: name".
+func (e *LoginEntry) attr(name string) (ret string, ok bool) {
+	for _, fields := range e.StringFields {
+		for k, v := range fields {
+			if strings.HasPrefix(k, RefFieldAttr) && strings.TrimSpace(k[len(RefFieldAttr):]) == name {
+				return v, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// RefError tells which reference failed to resolve.
+type RefError struct {
+	Ref string
+	Err error
+}
+
+func (e *RefError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Ref, e.Err)
+}
+
+func (e *RefError) Unwrap() error {
+	return e.Err
+}
+
+type ResolverOption func(*Resolver) error
+
+// WithCacheTTL sets how long entries are cached, 0 disables the cache.
+func WithCacheTTL(ttl time.Duration) ResolverOption {
+	return func(r *Resolver) error {
+		if ttl < 0 {
+			return fmt.Errorf("Invalid cache TTL: %v", ttl)
+		}
+		r.ttl = ttl
+		return nil
+	}
+}
+
+type resolverEntry struct {
+	entries []LoginEntry
+	expires time.Time
+}
+
+// Resolver resolves secret references with an associated client. The
+// entries of an URL are fetched once per batch and cached, so config
+// loaders and templates referencing the same entry many times cause one
+// get-logins only. It is safe for concurrent use, the requests to
+// KeePassXC are serialized.
+type Resolver struct {
+	client *Client
+	ttl    time.Duration
+	now    func() time.Time
+	mu     sync.Mutex
+	cache  map[string]*resolverEntry
+}
+
+func NewResolver(client *Client, opts ...ResolverOption) (ret *Resolver, err error) {
+	ret = &Resolver{
+		client: client,
+		ttl:    DefaultResolverCacheTTL,
+		now:    time.Now,
+		cache:  make(map[string]*resolverEntry),
+	}
+	for _, opt := range opts {
+		if err = opt(ret); err != nil {
+			return nil, err
+		}
+	}
+
+	return ret, nil
+}
+
+// Flush wipes the cached entries.
+func (r *Resolver) Flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for url, c := range r.cache {
+		WipeEntries(c.entries)
+		delete(r.cache, url)
+	}
+}
+
+// logins returns the entries of url, the caller holds mu and must not keep
+// them after releasing it.
+func (r *Resolver) logins(ctx context.Context, url string) (ret []LoginEntry, err error) {
+	now := r.now()
+	if c, ok := r.cache[url]; ok {
+		// without cache the entries live until the end of the batch
+		if r.ttl == 0 || now.Before(c.expires) {
+			return c.entries, nil
+		}
+		WipeEntries(c.entries)
+		delete(r.cache, url)
+	}
+	// a request in flight cannot be cancelled, the client timeout applies
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	res, err := r.client.GetLogins(url, "", "")
+	if err != nil {
+		perr, ok := err.(*ProtocolError)
+		if !ok || perr.Code != ErrCodeNoLoginsFound {
+			return nil, err
+		}
+		res = &MsgGetLogins{}
+	}
+	r.cache[url] = &resolverEntry{entries: res.Entries, expires: now.Add(r.ttl)}
+
+	return res.Entries, nil
+}
+
+// resolve returns a copy of the field, totps caches the TOTPs of the batch
+// by entry uuid.
+func (r *Resolver) resolve(ctx context.Context, ref *SecretRef, totps map[string]Secret) (ret Secret, err error) {
+	entries, err := r.logins(ctx, ref.Url)
+	if err != nil {
+		return nil, err
+	}
+	var e *LoginEntry
+	for i := range entries {
+		if ref.Login == "" || entries[i].Login == ref.Login {
+			e = &entries[i]
+			break
+		}
+	}
+	if e == nil {
+		return nil, NewProtocolError(ErrCodeNoLoginsFound, "No logins found")
+	}
+
+	switch ref.Field {
+	case RefFieldPassword:
+		return append(Secret(nil), e.Password...), nil
+	case RefFieldLogin:
+		return Secret(e.Login), nil
+	case RefFieldTotp:
+		totp, ok := totps[e.Uuid]
+		if !ok {
+			if err = ctx.Err(); err != nil {
+				return nil, err
+			}
+			res, err := r.client.GetTotp(e.Uuid)
+			if err != nil {
+				return nil, err
+			}
+			if len(res.Totp) == 0 {
+				return nil, NewProtocolError(ErrCodeNoValidUuidProvided, "No TOTP configured")
+			}
+			totp = res.Totp
+			totps[e.Uuid] = totp
+		}
+		return append(Secret(nil), totp...), nil
+	}
+
+	v, ok := e.attr(ref.Field[len(RefFieldAttr):])
+	if !ok {
+		return nil, fmt.Errorf("No attribute %s in entry %s", ref.Field, e.Uuid)
+	}
+
+	return Secret(v), nil
+}
+
+// Resolve returns the secret ref points at. The caller owns the returned
+// Secret and should wipe it after use.
+func (r *Resolver) Resolve(ctx context.Context, ref string) (ret Secret, err error) {
+	res, err := r.ResolveAll(ctx, []string{ref})
+	if err != nil {
+		return nil, err
+	}
+
+	return res[ref], nil
+}
+
+// ResolveAll resolves a batch of references, every URL is looked up once.
+// All references are parsed before the first request, on error nothing is
+// returned and the error is a *RefError.
+func (r *Resolver) ResolveAll(ctx context.Context, refs []string) (ret map[string]Secret, err error) {
+	parsed := make([]*SecretRef, len(refs))
+	for i, ref := range refs {
+		if parsed[i], err = ParseRef(ref); err != nil {
+			return nil, &RefError{Ref: ref, Err: err}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	totps := make(map[string]Secret)
+	defer func() {
+		for _, totp := range totps {
+			totp.Wipe()
+		}
+		if r.ttl == 0 {
+			for url, c := range r.cache {
+				WipeEntries(c.entries)
+				delete(r.cache, url)
+			}
+		}
+	}()
+
+	ret = make(map[string]Secret, len(refs))
+	for i, ref := range refs {
+		if _, ok := ret[ref]; ok {
+			continue
+		}
+		secret, err := r.resolve(ctx, parsed[i], totps)
+		if err != nil {
+			for _, s := range ret {
+				s.Wipe()
+			}
+			return nil, &RefError{Ref: ref, Err: err}
+		}
+		ret[ref] = secret
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,215 @@
+package keepassxc_browser
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// refBackend serves fixed entries for db.internal and counts the requests.
+type refBackend struct {
+	stubBackend
+	logins int32
+	totps  int32
+}
+
+func (b *refBackend) GetLogins(clientId string, req *MsgGetLogins) (*MsgGetLogins, error) {
+	atomic.AddInt32(&b.logins, 1)
+	if req.Url != "https://db.internal" {
+		return nil, NewProtocolError(ErrCodeNoLoginsFound, "No logins found")
+	}
+	return &MsgGetLogins{Entries: []LoginEntry{
+		{Login: "admin", Password: Secret("adminpw"), Uuid: "u1",
+			StringFields: []map[string]string{{"KPH: token": "t0k3n"}}},
+		{Login: "bob", Password: Secret("bobpw"), Uuid: "u2"},
+	}}, nil
+}
+
+func (b *refBackend) GetTotp(clientId string, req *MsgGetTotp) (*MsgGetTotp, error) {
+	n := atomic.AddInt32(&b.totps, 1)
+	return &MsgGetTotp{Totp: Secret(req.Uuid + "-" + string(rune('0'+n))), Uuid: req.Uuid}, nil
+}
+
+func newRefResolver(t *testing.T, opts ...ResolverOption) (*Resolver, *refBackend) {
+	t.Helper()
+	backend := &refBackend{}
+	c := newStubClient(t, backend)
+	if _, err := c.Associate(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewResolver(c, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r, backend
+}
+
+func TestParseRef(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want SecretRef
+	}{
+		{"kpxc://db.internal/password", SecretRef{"https://db.internal", "", "password"}},
+		{"kpxc://admin@db.internal/login", SecretRef{"https://db.internal", "admin", "login"}},
+		{"kpxc://db.internal:8443/app/totp?login=bob", SecretRef{"https://db.internal:8443/app", "bob", "totp"}},
+		{"kpxc://db.internal/password?scheme=http", SecretRef{"http://db.internal", "", "password"}},
+		{"kpxc://ci.internal/KPH:token", SecretRef{"https://ci.internal", "", "KPH:token"}},
+		{"kpxc://ci.internal/KPH: token", SecretRef{"https://ci.internal", "", "KPH:token"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseRef(tt.ref)
+		if err != nil {
+			t.Errorf("%s: %v", tt.ref, err)
+			continue
+		}
+		if *got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.ref, *got, tt.want)
+		}
+		// String gives an equivalent reference
+		if again, err := ParseRef(got.String()); err != nil || *again != *got {
+			t.Errorf("%s: %s parsed as %+v, %v", tt.ref, got.String(), again, err)
+		}
+	}
+
+	for _, ref := range []string{
+		"",
+		"https://db.internal/password",
+		"kpxc:///password",
+		"kpxc://db.internal",
+		"kpxc://db.internal/",
+		"kpxc://db.internal/secret",
+		"kpxc://db.internal/KPH:",
+		"kpxc://db.internal/KPH: ",
+		"kpxc://%zz/password",
+	} {
+		if got, err := ParseRef(ref); err == nil {
+			t.Errorf("%q accepted as %+v", ref, got)
+		}
+	}
+}
+
+func TestResolverBatch(t *testing.T) {
+	r, backend := newRefResolver(t)
+	ctx := context.Background()
+	refs := []string{
+		"kpxc://db.internal/password",
+		"kpxc://bob@db.internal/password",
+		"kpxc://db.internal/login",
+		"kpxc://db.internal/KPH:token",
+		"kpxc://db.internal/totp",
+		"kpxc://admin@db.internal/totp",
+		"kpxc://db.internal/password",
+	}
+	res, err := r.ResolveAll(ctx, refs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"kpxc://db.internal/password":     "adminpw",
+		"kpxc://bob@db.internal/password": "bobpw",
+		"kpxc://db.internal/login":        "admin",
+		"kpxc://db.internal/KPH:token":    "t0k3n",
+		"kpxc://db.internal/totp":         "u1-1",
+		"kpxc://admin@db.internal/totp":   "u1-1",
+	}
+	for ref, w := range want {
+		if string(res[ref]) != w {
+			t.Errorf("%s: got %q, want %q", ref, res[ref], w)
+		}
+	}
+	if n := atomic.LoadInt32(&backend.logins); n != 1 {
+		t.Errorf("%d get-logins for one URL, want 1", n)
+	}
+	if n := atomic.LoadInt32(&backend.totps); n != 1 {
+		t.Errorf("%d get-totp for one entry, want 1", n)
+	}
+
+	// TOTPs are not cached across batches
+	totp, err := r.Resolve(ctx, "kpxc://db.internal/totp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(totp) != "u1-2" {
+		t.Errorf("got TOTP %s, want a new one", totp)
+	}
+
+	// a malformed reference fails the batch before any request
+	var rerr *RefError
+	if _, err = r.ResolveAll(ctx, []string{"kpxc://other.internal/password", "kpxc://db.internal/nope"}); !errors.As(err, &rerr) || rerr.Ref != "kpxc://db.internal/nope" {
+		t.Errorf("got %v, want a RefError for the malformed reference", err)
+	}
+	if n := atomic.LoadInt32(&backend.logins); n != 1 {
+		t.Errorf("%d get-logins, want none for a failed parse", n)
+	}
+
+	for ref, code := range map[string]int{
+		"kpxc://other.internal/password":  ErrCodeNoLoginsFound,
+		"kpxc://eve@db.internal/password": ErrCodeNoLoginsFound,
+		"kpxc://db.internal/KPH:missing":  0,
+	} {
+		_, err = r.Resolve(ctx, ref)
+		if !errors.As(err, &rerr) || rerr.Ref != ref {
+			t.Errorf("%s: got %v, want a RefError", ref, err)
+			continue
+		}
+		var perr *ProtocolError
+		if code != 0 && (!errors.As(err, &perr) || perr.Code != code) {
+			t.Errorf("%s: got %v, want code %d", ref, err, code)
+		}
+	}
+}
+
+func TestResolverCache(t *testing.T) {
+	r, backend := newRefResolver(t, WithCacheTTL(time.Minute))
+	now := time.Unix(0, 0)
+	r.now = func() time.Time { return now }
+	ctx := context.Background()
+	ref := "kpxc://db.internal/password"
+
+	get := func() {
+		t.Helper()
+		if s, err := r.Resolve(ctx, ref); err != nil || string(s) != "adminpw" {
+			t.Fatalf("got %q, %v", s, err)
+		}
+	}
+	get()
+	now = now.Add(30 * time.Second)
+	get()
+	if n := atomic.LoadInt32(&backend.logins); n != 1 {
+		t.Errorf("%d get-logins within the TTL, want 1", n)
+	}
+	now = now.Add(time.Minute)
+	get()
+	if n := atomic.LoadInt32(&backend.logins); n != 2 {
+		t.Errorf("%d get-logins after expiry, want 2", n)
+	}
+
+	r.Flush()
+	get()
+	if n := atomic.LoadInt32(&backend.logins); n != 3 {
+		t.Errorf("%d get-logins after Flush, want 3", n)
+	}
+
+	// without cache every batch asks
+	r, backend = newRefResolver(t, WithCacheTTL(0))
+	get()
+	get()
+	if n := atomic.LoadInt32(&backend.logins); n != 2 {
+		t.Errorf("%d get-logins without cache, want 2", n)
+	}
+	if len(r.cache) != 0 {
+		t.Error("entries kept without cache")
+	}
+
+	if _, err := NewResolver(nil, WithCacheTTL(-1)); err == nil {
+		t.Error("negative TTL accepted")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := r.Resolve(ctx, ref); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,154 @@
+package keepassxc_browser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Secret holds sensitive values like passwords or TOTP codes in a byte slice
+// so it can be wiped after use. It is (un)marshaled as a JSON string without
+// going through an intermediate go string. Bytes which are no valid UTF-8
+// are escaped as lone surrogates \udc80 to \udcff, so they survive the round
+// trip unchanged.
+type Secret []byte
+
+func (s Secret) Wipe() {
+	wipeBytes(s)
+}
+
+func (s Secret) Equal(o Secret) bool {
+	return bytes.Equal(s, o)
+}
+
+func (s Secret) MarshalJSON() (ret []byte, err error) {
+	ret = make([]byte, 0, len(s)+2)
+	ret = append(ret, '"')
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRune(s[i:])
+		switch {
+		case r == '"' || r == '\\':
+			ret = append(ret, '\\', byte(r))
+		case r == utf8.RuneError && size == 1:
+			ret = append(ret, fmt.Sprintf("\\u%04x", surrogateEscape+rune(s[i]))...)
+		case r < 0x20:
+			ret = append(ret, fmt.Sprintf("\\u%04x", r)...)
+		default:
+			ret = append(ret, s[i:i+size]...)
+		}
+		i += size
+	}
+	ret = append(ret, '"')
+
+	return ret, nil
+}
+
+func (s *Secret) UnmarshalJSON(data []byte) (err error) {
+	if bytes.Equal(data, []byte("null")) {
+		*s = nil
+		return nil
+	}
+	if len(data) < 2 || data[0] != '"' || data[len(data)-1] != '"' {
+		return fmt.Errorf("Invalid secret: not a JSON string")
+	}
+	data = data[1 : len(data)-1]
+
+	// the old value may be shared, it is replaced and left alone
+	ret := make(Secret, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if c != '\\' {
+			ret = append(ret, c)
+			continue
+		}
+		i++
+		if i >= len(data) {
+			ret.Wipe()
+			return fmt.Errorf("Invalid secret: truncated escape")
+		}
+		switch data[i] {
+		case '"', '\\', '/':
+			ret = append(ret, data[i])
+		case 'b':
+			ret = append(ret, '\b')
+		case 'f':
+			ret = append(ret, '\f')
+		case 'n':
+			ret = append(ret, '\n')
+		case 'r':
+			ret = append(ret, '\r')
+		case 't':
+			ret = append(ret, '\t')
+		case 'u':
+			r, n := unquoteRune(data[i+1:])
+			if n == 0 {
+				ret.Wipe()
+				return fmt.Errorf("Invalid secret: bad unicode escape")
+			}
+			if r >= surrogateEscape+0x80 && r <= surrogateEscape+0xff {
+				ret = append(ret, byte(r-surrogateEscape))
+			} else {
+				ret = utf8.AppendRune(ret, r)
+			}
+			i += n
+		default:
+			ret.Wipe()
+			return fmt.Errorf("Invalid secret: unknown escape")
+		}
+	}
+	*s = ret
+
+	return nil
+}
+
+// surrogateEscape plus a byte which is no valid UTF-8 is the lone
+// surrogate it is escaped as.
+const surrogateEscape rune = 0xdc00
+
+// unquoteRune decodes the hex digits following a \u escape including a
+// trailing surrogate pair and returns the rune and the bytes consumed. A
+// lone surrogate is returned as is.
+func unquoteRune(data []byte) (r rune, n int) {
+	r1, ok := hexRune(data)
+	if !ok {
+		return 0, 0
+	}
+	if !utf16.IsSurrogate(r1) {
+		return r1, 4
+	}
+	if len(data) >= 10 && data[4] == '\\' && data[5] == 'u' {
+		if r2, ok := hexRune(data[6:]); ok {
+			if r = utf16.DecodeRune(r1, r2); r != utf8.RuneError {
+				return r, 10
+			}
+		}
+	}
+
+	return r1, 4
+}
+
+func hexRune(data []byte) (r rune, ok bool) {
+	if len(data) < 4 {
+		return 0, false
+	}
+	for _, c := range data[:4] {
+		switch {
+		case '0' <= c && c <= '9':
+			c = c - '0'
+		case 'a' <= c && c <= 'f':
+			c = c - 'a' + 10
+		case 'A' <= c && c <= 'F':
+			c = c - 'A' + 10
+		default:
+			return 0, false
+		}
+		r = r*16 + rune(c)
+	}
+
+	return r, true
+}
+
+var _ json.Marshaler = Secret(nil)
+var _ json.Unmarshaler = (*Secret)(nil)
@@ -0,0 +1,85 @@
+package keepassxc_browser
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSecretRoundTrip(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"plain",
+		`quote " backslash \ slash /`,
+		"control \x00\x01\n\r\t\x1f",
+		"unicode äöü € 𝄞",
+		"replacement �",
+		"invalid \xff\xfe \xc3 \xed\xa0\x80",
+		"\x80",
+	} {
+		data, err := json.Marshal(Secret(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !json.Valid(data) {
+			t.Errorf("%q: invalid JSON %s", s, data)
+		}
+		var got Secret
+		if err = json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("%q: %v", s, err)
+		}
+		if string(got) != s {
+			t.Errorf("got %q, want %q", got, s)
+		}
+	}
+}
+
+// TestSecretEscaping checks the encoding against encoding/json for strings
+// any JSON implementation agrees on.
+func TestSecretEscaping(t *testing.T) {
+	for _, s := range []string{`a"b\c`, "x\ny\tz\x00", "<&> 𝄞"} {
+		data, _ := json.Marshal(Secret(s))
+		var str string
+		if err := json.Unmarshal(data, &str); err != nil || str != s {
+			t.Errorf("%s: encoding/json got %q, %v, want %q", data, str, err, s)
+		}
+		data, _ = json.Marshal(s)
+		var got Secret
+		if err := json.Unmarshal(data, &got); err != nil || string(got) != s {
+			t.Errorf("%s: got %q, %v, want %q", data, got, err, s)
+		}
+	}
+
+	for in, want := range map[string]string{
+		`"ä\/"`:          "ä/",
+		`"𝄞"`:            "𝄞",
+		`"\b\f"`:         "\b\f",
+		`null`:           "",
+		`"\udcff\udc80"`: "\xff\x80",
+		`"\ud834 lone"`:  "� lone",
+		`"\udc7f low"`:   "� low",
+		`"AB"`:           "AB",
+		`"tab\there!"`:   "tab\there!",
+	} {
+		var got Secret
+		if err := json.Unmarshal([]byte(in), &got); err != nil || string(got) != want {
+			t.Errorf("%s: got %q, %v, want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{`"\x"`, `"\u12"`, `"\u12zz"`, `123`, `"\`} {
+		var got Secret
+		if err := got.UnmarshalJSON([]byte(in)); err == nil {
+			t.Errorf("%s accepted", in)
+		}
+	}
+}
+
+func TestSecretUnmarshalShared(t *testing.T) {
+	shared := Secret("shared")
+	s := shared
+	if err := json.Unmarshal([]byte(`"new"`), &s); err != nil {
+		t.Fatal(err)
+	}
+	if string(s) != "new" || string(shared) != "shared" {
+		t.Errorf("got %q, shared buffer %q", s, shared)
+	}
+}
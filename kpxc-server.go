@@ -1,65 +1,191 @@
 package keepassxc_browser
 
 import (
-	"encoding/binary"
-	"fmt"
-
-	"github.com/jamesruan/sodium"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
 )
 
 type ServerI interface {
 	HandleReq([]byte) ([]byte, error)
 }
 
+type ServerOption func(*Server) error
+
 type Server struct {
-	conn         ConnectionI
-	serv         ServerI
-	keyPair      sodium.BoxKP
-	clientPubKey sodium.BoxPublicKey
+	conn    ConnectionI
+	serv    ServerI
+	logger  LoggerI
+	onError func(error)
 }
 
-func (s *Server) recvReq(timeout int) (err error) {
-	jreq, err := s.conn.Recv(BufSize, timeout)
-	if err != nil {
-		return err
+type serverRecv struct {
+	data  []byte
+	err   error
+	fatal bool
+}
+
+// maxRecvErrors is the number of failed reads in a row after which Serve
+// gives up on the connection.
+const maxRecvErrors int = 3
+
+func WithServerLogger(logger LoggerI) ServerOption {
+	return func(s *Server) error {
+		if logger == nil {
+			logger = nopLogger{}
+		}
+		s.logger = logger
+		return nil
 	}
+}
 
-	hres, err := s.serv.HandleReq(jreq)
-	if err != nil {
-		return err
+// WithErrorHandler installs a callback for recoverable errors, the server
+// keeps serving after it returns.
+func WithErrorHandler(handler func(error)) ServerOption {
+	return func(s *Server) error {
+		s.onError = handler
+		return nil
 	}
+}
+
+// IsFatalConnErr reports whether err means the transport is gone.
+func IsFatalConnErr(err error) bool {
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.ErrClosedPipe) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, os.ErrClosed) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
 
-	ljres := make([]byte, 4)
-	binary.LittleEndian.PutUint32(ljres, uint32(len(hres)))
-	hres = append(ljres, hres...)
+func (s *Server) reportErr(err error) {
+	s.logger.Printf("Error: %v\n", err)
+	if s.onError != nil {
+		s.onError(err)
+	}
+}
 
-	if err = s.conn.Send(hres); err != nil {
+// handleReq answers jreq, a failed request is answered with an error reply
+// since the client waits for an answer to every request.
+func (s *Server) handleReq(jreq []byte) (err error) {
+	hres, err := s.serv.HandleReq(jreq)
+	if err != nil {
+		var req ConnMsg
+		json.Unmarshal(jreq, &req)
+		eres, rerr := ErrorReply(&req, err)
+		if rerr == nil {
+			rerr = s.conn.Send(eres)
+		}
+		if rerr != nil && IsFatalConnErr(rerr) {
+			return rerr
+		}
 		return err
 	}
 
-	return err
+	return s.conn.Send(hres)
 }
 
-func (s *Server) Run() (err error) {
+// Serve handles requests until ctx is cancelled, the client closes the
+// connection (returns nil) or the transport fails. Errors of single
+// requests are answered with an error reply and reported to the logger and
+// error handler, so are failed reads unless the transport is gone or
+// maxRecvErrors reads failed in a row. Messages of a handler implementing
+// NotifierI are sent to the client between requests.
+func (s *Server) Serve(ctx context.Context) (err error) {
 	if err = s.conn.Connect(""); err != nil {
 		return err
 	}
+	defer s.conn.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// reading may block without a way to interrupt it (stdin), so do it
+	// in the background to be able to return on cancellation
+	reqs := make(chan serverRecv)
+	go func() {
+		errs := 0
+		for {
+			jreq, err := s.conn.Recv(BufSize, 0)
+			r := serverRecv{data: jreq, err: err}
+			if err != nil {
+				errs++
+				r.fatal = err == io.EOF || IsFatalConnErr(err) || errs >= maxRecvErrors
+			} else {
+				errs = 0
+			}
+			select {
+			case reqs <- r:
+			case <-ctx.Done():
+				return
+			}
+			if r.fatal {
+				return
+			}
+		}
+	}()
+
+	var notes <-chan []byte
+	if n, ok := s.serv.(NotifierI); ok {
+		notes = n.Notifications()
+	}
 
 	for {
-		err = s.recvReq(30)
-		if err != nil {
-			fmt.Printf("Error: %v\n", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-notes:
+			if !ok {
+				notes = nil
+				break
+			}
+			if err = s.conn.Send(msg); err != nil {
+				if IsFatalConnErr(err) {
+					return err
+				}
+				s.reportErr(err)
+			}
+		case r := <-reqs:
+			if r.err == io.EOF {
+				return nil
+			}
+			if r.fatal {
+				return r.err
+			}
+			if r.err != nil {
+				s.reportErr(r.err)
+				break
+			}
+			if err = s.handleReq(r.data); err != nil {
+				if IsFatalConnErr(err) {
+					return err
+				}
+				s.reportErr(err)
+			}
 		}
 	}
+}
 
-	//return err
+func (s *Server) Run() (err error) {
+	return s.Serve(context.Background())
 }
 
-func NewServer(serv ServerI, conn ConnectionI) (ret *Server) {
+func NewServer(serv ServerI, conn ConnectionI, opts ...ServerOption) (ret *Server, err error) {
 	ret = new(Server)
 	ret.serv = serv
 	ret.conn = conn
-	ret.keyPair = sodium.MakeBoxKP()
+	ret.logger = nopLogger{}
+
+	for _, opt := range opts {
+		if err = opt(ret); err != nil {
+			return nil, err
+		}
+	}
 
-	return ret
+	return ret, nil
 }
@@ -0,0 +1,144 @@
+package keepassxc_browser
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+type serverFunc func(req []byte) ([]byte, error)
+
+func (f serverFunc) HandleReq(req []byte) ([]byte, error) {
+	return f(req)
+}
+
+// notifyServer is an echo handler sending notes between requests.
+type notifyServer struct {
+	notes chan []byte
+}
+
+func (s *notifyServer) HandleReq(req []byte) ([]byte, error) { return req, nil }
+func (s *notifyServer) Notifications() <-chan []byte         { return s.notes }
+
+// serveNative runs a Server for handler on native messaging pipes, the
+// returned functions write a request and read a response.
+func serveNative(t *testing.T, ctx context.Context, handler ServerI, opts ...ServerOption) (send func(msg []byte), recv func() string, done chan error) {
+	t.Helper()
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	srv, err := NewServer(handler, &StdinoutConnection{In: inR, Out: outW}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done = make(chan error, 1)
+	go func() {
+		done <- srv.Serve(ctx)
+		outW.Close()
+	}()
+	t.Cleanup(func() { inW.Close() })
+
+	send = func(msg []byte) {
+		t.Helper()
+		if err := writeFrame(inW, NativeEndian, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recv = func() string {
+		t.Helper()
+		msg, err := readFrame(outR, NativeEndian, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(msg)
+	}
+
+	return send, recv, done
+}
+
+func TestServerErrorReply(t *testing.T) {
+	var reported []error
+	handler := serverFunc(func(req []byte) ([]byte, error) {
+		if bytes.Contains(req, []byte("fail")) {
+			return nil, fmt.Errorf("Handler failed")
+		}
+		return req, nil
+	})
+	send, recv, _ := serveNative(t, context.Background(), handler,
+		WithErrorHandler(func(err error) { reported = append(reported, err) }))
+
+	send([]byte(`{"action":"get-logins","requestID":"fail"}`))
+	res := recv()
+	if !strings.Contains(res, `"requestID":"fail"`) || !strings.Contains(res, `"errorCode":"6"`) {
+		t.Errorf("got %s, want an error reply", res)
+	}
+
+	// a frame over the limit is skipped
+	send(make([]byte, BufSize+1))
+	send([]byte(`{"action":"get-databasehash"}`))
+	if res = recv(); res != `{"action":"get-databasehash"}` {
+		t.Errorf("got %s after an oversized frame", res)
+	}
+	if len(reported) != 2 {
+		t.Errorf("reported %v, want the handler error and the oversized frame", reported)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	// the client closing its end
+	inR, inW := io.Pipe()
+	srv, _ := NewServer(serverFunc(func(req []byte) ([]byte, error) { return req, nil }),
+		&StdinoutConnection{In: inR, Out: io.Discard})
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(context.Background()) }()
+	inW.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("got %v, want nil on end of input", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return on end of input")
+	}
+
+	// cancellation while the read blocks
+	ctx, cancel := context.WithCancel(context.Background())
+	_, _, done = serveNative(t, ctx, serverFunc(func(req []byte) ([]byte, error) { return req, nil }))
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("got %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return on cancellation")
+	}
+
+	// a truncated frame is fatal
+	var buf bytes.Buffer
+	binary.Write(&buf, NativeEndian, uint32(10))
+	buf.WriteString("{}")
+	srv, _ = NewServer(serverFunc(func(req []byte) ([]byte, error) { return req, nil }),
+		&StdinoutConnection{In: &buf, Out: io.Discard})
+	if err := srv.Serve(context.Background()); err != io.ErrUnexpectedEOF {
+		t.Errorf("got %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestServerNotifications(t *testing.T) {
+	h := &notifyServer{notes: make(chan []byte, 1)}
+	send, recv, _ := serveNative(t, context.Background(), h)
+
+	h.notes <- []byte(`{"action":"database-locked"}`)
+	if res := recv(); res != `{"action":"database-locked"}` {
+		t.Errorf("got %s, want the notification", res)
+	}
+	send([]byte(`{"action":"get-databasehash"}`))
+	if res := recv(); res != `{"action":"get-databasehash"}` {
+		t.Errorf("got %s", res)
+	}
+}
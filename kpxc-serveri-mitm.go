@@ -3,100 +3,397 @@ package keepassxc_browser
 import (
 	"encoding/base64"
 	"encoding/json"
-	"fmt"
-	"os"
-	"path"
-	"runtime"
-
-	"github.com/jamesruan/sodium"
+	"sync"
+	"time"
 )
 
+// DefaultMitmSessionTimeout is how long the crypto state of a client is
+// kept without requests.
+var DefaultMitmSessionTimeout = 30 * time.Minute
+
 type KpXcMitmI interface {
 	ModifyReq(*ConnMsg) error
 	ModifyRes(*ConnMsg) error
 }
 
+// MitmReply is returned by ModifyReq of a modifier answering the request
+// itself, Data is encrypted for the client and nothing is forwarded.
+type MitmReply struct {
+	Data MsgI
+}
+
+func (r *MitmReply) Error() string {
+	return "Answered by modifier"
+}
+
+// mitmSession is the crypto state of one client. KeePassXC keeps a single
+// client key per connection, so every session has its own upstream
+// connection. Its notifications go to the client connection which started
+// it.
+type mitmSession struct {
+	mu           sync.Mutex
+	pump         *upstreamPump
+	notify       chan<- []byte
+	closed       bool
+	keyPair      BoxKP
+	clientPubKey BoxPublicKey
+	serverPubKey BoxPublicKey
+	lastUsed     time.Time
+}
+
+func (s *mitmSession) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	wipeBytes(s.keyPair.SecretKey.Bytes)
+	s.pump.Close()
+}
+
+func (s *mitmSession) upstream() *upstreamPump {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pump
+}
+
+// handshake exchanges the keys of keyPair with KeePassXC on the new
+// connection p and returns the server key, the client keeps using its keys
+// with the MITM.
+func handshake(p *upstreamPump, keyPair BoxKP, clientId string) (ret []byte, err error) {
+	req, err := GenerateConnReq("change-public-keys", clientId)
+	if err != nil {
+		return nil, err
+	}
+	req.PublicKey = base64.StdEncoding.EncodeToString(keyPair.PublicKey.Bytes)
+	jreq, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	jres, err := p.Exchange(jreq, UpstreamHandshakeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	res, err := ParseConnMsg(jres)
+	if err != nil {
+		return nil, err
+	}
+	if res.PublicKey == "" {
+		return nil, NewProtocolError(ErrCodeKeyChangeFailed, "Key change was not successful")
+	}
+
+	return base64.StdEncoding.DecodeString(res.PublicKey)
+}
+
+// reconnect replaces a lost upstream connection, e.g. after KeePassXC was
+// restarted. It dials and exchanges the keys without holding the session,
+// so a hanging KeePassXC does not block closing it.
+func (s *mitmSession) reconnect(dialer *upstreamDialer, clientId string) (err error) {
+	s.mu.Lock()
+	if !s.pump.closed() {
+		s.mu.Unlock()
+		return nil
+	}
+	keyPair := s.keyPair
+	s.mu.Unlock()
+
+	conn, err := dialer.dial()
+	if err != nil {
+		return err
+	}
+	p := newUpstreamPump(conn, s.notify)
+	serverPubKey, err := handshake(p, keyPair, clientId)
+	if err != nil {
+		p.Close()
+		return errNotRunning()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		p.Close()
+		return errNotRunning()
+	}
+	if !s.pump.closed() {
+		// another request was faster
+		p.Close()
+		return nil
+	}
+	s.pump = p
+	s.serverPubKey.Bytes = serverPubKey
+
+	return nil
+}
+
+// mitmKey identifies a session, client ids are chosen by the clients, so
+// they only count within the connection of the client.
+type mitmKey struct {
+	client   *KpXcMitmClient
+	clientId string
+}
+
 type kpXcModifier struct {
-	keyPair      sodium.BoxKP
-	clientPubKey sodium.BoxPublicKey
-	serverPubKey sodium.BoxPublicKey
-	modifier     KpXcMitmI
+	mu       sync.Mutex
+	sessions map[mitmKey]*mitmSession
+	timeout  time.Duration
+	dialer   *upstreamDialer
+	notify   chan []byte
+	modifier KpXcMitmI
 }
 
+// KpXcMitm decrypts the messages of its clients, hands them to the
+// modifiers and encrypts them again for KeePassXC. It can be shared by
+// several client connections, each one needs its own handler from
+// NewClient then to receive its notifications.
 type KpXcMitm struct {
-	conn     ConnectionI
 	modifier *kpXcModifier
+	client   *KpXcMitmClient
+	audit    *AuditLog
+}
+
+// KpXcMitmClient is the handler of one client connection of a shared
+// KpXcMitm. The sessions of a client connection are its own, other
+// connections cannot take them over by using the same client id.
+type KpXcMitmClient struct {
+	mitm   *KpXcMitm
+	notify chan []byte
+}
+
+func (c *KpXcMitmClient) HandleReq(breq []byte) (bres []byte, err error) {
+	return c.mitm.handleReq(breq, c)
+}
+
+// Close ends the sessions of the client connection, SocketServer calls it
+// once the client is gone.
+func (c *KpXcMitmClient) Close() {
+	m := c.mitm.modifier
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, s := range m.sessions {
+		if key.client == c {
+			s.close()
+			delete(m.sessions, key)
+		}
+	}
+}
+
+// Notifications returns the messages KeePassXC sent without a request on
+// the sessions started by this client, they are passed on unmodified.
+func (c *KpXcMitmClient) Notifications() <-chan []byte {
+	return c.notify
+}
+
+// NewClient returns a handler for a new client connection.
+func (k *KpXcMitm) NewClient() *KpXcMitmClient {
+	return &KpXcMitmClient{mitm: k, notify: make(chan []byte, NotifyBufSize)}
 }
 
-func (m *kpXcModifier) ModifyReq(req *ConnMsg) (err error) {
+// Factory returns a ServerFactory for SocketServer handing every client
+// connection its own KpXcMitmClient.
+func (k *KpXcMitm) Factory() ServerFactory {
+	return func() (ServerI, error) {
+		return k.NewClient(), nil
+	}
+}
+
+// SetAuditLog records every request with its outcome to audit, nil stops
+// recording. Forwarded requests are recorded as pending first. Requests are
+// denied if their records cannot be written.
+func (k *KpXcMitm) SetAuditLog(audit *AuditLog) {
+	k.audit = audit
+}
+
+// Notifications returns the messages KeePassXC sent without a request on
+// the sessions started through HandleReq, they are passed on unmodified.
+func (k *KpXcMitm) Notifications() <-chan []byte {
+	return k.client.notify
+}
+
+// expire drops the sessions idle for longer than the timeout, the caller
+// holds m.mu.
+func (m *kpXcModifier) expire(now time.Time) {
+	if m.timeout <= 0 {
+		return
+	}
+	for key, s := range m.sessions {
+		if now.Sub(s.lastUsed) > m.timeout {
+			s.close()
+			delete(m.sessions, key)
+		}
+	}
+}
+
+// newSession replaces the session of clientId on the connection of c with
+// one for the client key publicKey, a client repeating the key exchange
+// starts over on a new upstream connection.
+func (m *kpXcModifier) newSession(c *KpXcMitmClient, clientId, publicKey string) (ret *mitmSession, err error) {
+	// nothing is dialed for invalid keys
+	pubKey, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(pubKey) != BoxKeySize {
+		return nil, NewProtocolError(ErrCodeKeyChangeFailed, "Key change was not successful")
+	}
+
+	ret = new(mitmSession)
+	ret.notify = c.notify
+	ret.clientPubKey.Bytes = pubKey
+	if ret.keyPair, err = MakeBoxKP(); err != nil {
+		return nil, err
+	}
+	conn, err := m.dialer.dial()
+	if err != nil {
+		return nil, err
+	}
+	ret.pump = newUpstreamPump(conn, c.notify)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.expire(now)
+	ret.lastUsed = now
+	key := mitmKey{client: c, clientId: clientId}
+	if old, ok := m.sessions[key]; ok {
+		old.close()
+	}
+	m.sessions[key] = ret
+
+	return ret, nil
+}
+
+func (m *kpXcModifier) session(c *KpXcMitmClient, clientId string) (ret *mitmSession, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.expire(now)
+	ret, ok := m.sessions[mitmKey{client: c, clientId: clientId}]
+	if !ok {
+		return nil, NewProtocolError(ErrCodeClientPublicKeyNotReceived, "Client public key not received")
+	}
+	ret.lastUsed = now
+
+	return ret, nil
+}
+
+// Sessions returns the number of clients with crypto state.
+func (k *KpXcMitm) Sessions() int {
+	k.modifier.mu.Lock()
+	defer k.modifier.mu.Unlock()
+	k.modifier.expire(time.Now())
+	return len(k.modifier.sessions)
+}
+
+// SetSessionTimeout sets how long idle client sessions are kept, zero
+// keeps them forever.
+func (k *KpXcMitm) SetSessionTimeout(timeout time.Duration) {
+	k.modifier.mu.Lock()
+	k.modifier.timeout = timeout
+	k.modifier.mu.Unlock()
+}
+
+// modifyReq prepares req of the client connection c for KeePassXC. The
+// audit record is made before the modifiers run, it holds what the client
+// asked for.
+func (m *kpXcModifier) modifyReq(req *ConnMsg, c *KpXcMitmClient) (rec *AuditRecord, err error) {
+	var s *mitmSession
 	encrypt := false
+	rec = newAuditRecord(req)
 
 	switch req.ActionName {
 	case "change-public-keys":
-		if m.clientPubKey.Bytes, err = base64.StdEncoding.DecodeString(req.PublicKey); err != nil {
-			return err
+		if s, err = m.newSession(c, req.ClientId, req.PublicKey); err != nil {
+			return rec, err
 		}
-		req.PublicKey = base64.StdEncoding.EncodeToString(m.keyPair.PublicKey.Bytes)
+		req.PublicKey = base64.StdEncoding.EncodeToString(s.keyPair.PublicKey.Bytes)
 		break
 	default:
 		if req.Message != "" && req.Nonce != "" && req.data != nil {
+			if s, err = m.session(c, req.ClientId); err != nil {
+				return rec, err
+			}
+			if err = s.reconnect(m.dialer, req.ClientId); err != nil {
+				return rec, err
+			}
 			jedata, err := base64.StdEncoding.DecodeString(req.Message)
 			if err != nil {
-				return err
+				return rec, err
 			}
-			jdata, err := DecryptBytes(req.nonce, m.clientPubKey, m.keyPair.SecretKey, jedata)
+			jdata, err := DecryptBytes(req.nonce, s.clientPubKey, s.keyPair.SecretKey, jedata)
 			if err != nil {
-				return err
+				return rec, err
 			}
-			if err = json.Unmarshal(jdata, req.data); err != nil {
-				return err
+			err = json.Unmarshal(jdata, req.data)
+			wipeBytes(jdata)
+			if err != nil {
+				return rec, err
 			}
+			rec = newAuditRecord(req)
 
 			if req.ActionName == "associate" {
-				req.data.(*MsgAssociate).Key = base64.StdEncoding.EncodeToString(m.keyPair.PublicKey.Bytes)
+				req.data.(*MsgAssociate).Key = base64.StdEncoding.EncodeToString(s.keyPair.PublicKey.Bytes)
 			}
 			encrypt = true
 		}
 		break
 	}
 
-	if err = m.modifier.ModifyReq(req); err != nil {
-		return err
+	if m.modifier != nil {
+		if err = m.modifier.ModifyReq(req); err != nil {
+			return rec, err
+		}
 	}
 
 	if encrypt {
 		jdata, err := json.Marshal(req.data)
 		if err != nil {
-			return err
+			return rec, err
+		}
+		jedata, err := EncryptBytes(req.nonce, s.serverPubKey, s.keyPair.SecretKey, jdata)
+		wipeBytes(jdata)
+		req.Wipe()
+		if err != nil {
+			return rec, err
 		}
-		jedata := EncryptBytes(req.nonce, m.serverPubKey, m.keyPair.SecretKey, jdata)
 		req.Message = base64.StdEncoding.EncodeToString(jedata)
 	}
 
-	return nil
+	return rec, nil
 }
 
-func (m *kpXcModifier) ModifyRes(res *ConnMsg) (err error) {
+// modifyRes prepares res of KeePassXC for the client connection c.
+func (m *kpXcModifier) modifyRes(res *ConnMsg, c *KpXcMitmClient) (err error) {
+	var s *mitmSession
 	encrypt := false
 
+	// the session is the one of the request, responses may lack a client id
+	clientId := res.ClientId
+	if req := res.Request(); req != nil {
+		clientId = req.ClientId
+	}
+
 	switch res.ActionName {
 	case "change-public-keys":
-		if m.serverPubKey.Bytes, err = base64.StdEncoding.DecodeString(res.PublicKey); err != nil {
+		if s, err = m.session(c, clientId); err != nil {
+			return err
+		}
+		if s.serverPubKey.Bytes, err = base64.StdEncoding.DecodeString(res.PublicKey); err != nil {
 			return err
 		}
-		res.PublicKey = base64.StdEncoding.EncodeToString(m.keyPair.PublicKey.Bytes)
+		res.PublicKey = base64.StdEncoding.EncodeToString(s.keyPair.PublicKey.Bytes)
 		break
 	default:
 		if res.Message != "" && res.Nonce != "" && res.data != nil {
+			if s, err = m.session(c, clientId); err != nil {
+				return err
+			}
 			jedata, err := base64.StdEncoding.DecodeString(res.Message)
 			if err != nil {
 				return err
 			}
-			jdata, err := DecryptBytes(res.nonce, m.serverPubKey, m.keyPair.SecretKey, jedata)
+			jdata, err := DecryptBytes(res.nonce, s.serverPubKey, s.keyPair.SecretKey, jedata)
 			if err != nil {
 				return err
 			}
-			if err = json.Unmarshal(jdata, res.data); err != nil {
+			err = json.Unmarshal(jdata, res.data)
+			wipeBytes(jdata)
+			if err != nil {
 				return err
 			}
 			encrypt = true
@@ -104,8 +401,10 @@ func (m *kpXcModifier) ModifyRes(res *ConnMsg) (err error) {
 		break
 	}
 
-	if err = m.modifier.ModifyRes(res); err != nil {
-		return err
+	if m.modifier != nil {
+		if err = m.modifier.ModifyRes(res); err != nil {
+			return err
+		}
 	}
 
 	if encrypt {
@@ -113,20 +412,62 @@ func (m *kpXcModifier) ModifyRes(res *ConnMsg) (err error) {
 		if err != nil {
 			return err
 		}
-		jedata := EncryptBytes(res.nonce, m.clientPubKey, m.keyPair.SecretKey, jdata)
+		jedata, err := EncryptBytes(res.nonce, s.clientPubKey, s.keyPair.SecretKey, jdata)
+		wipeBytes(jdata)
+		res.Wipe()
+		if err != nil {
+			return err
+		}
 		res.Message = base64.StdEncoding.EncodeToString(jedata)
 	}
 
 	return nil
 }
 
+func (m *kpXcModifier) reply(req *ConnMsg, c *KpXcMitmClient, data MsgI) (ret []byte, err error) {
+	s, err := m.session(c, req.ClientId)
+	if err != nil {
+		return ErrorReply(req, err)
+	}
+
+	return encryptedReply(req, data, s.keyPair, s.clientPubKey)
+}
+
+// HandleReq serves a single client connection, use NewClient for more.
 func (k *KpXcMitm) HandleReq(breq []byte) (bres []byte, err error) {
+	return k.handleReq(breq, k.client)
+}
+
+func (k *KpXcMitm) handleReq(breq []byte, c *KpXcMitmClient) (bres []byte, err error) {
 	req, err := ParseConnMsg(breq)
 	if err != nil {
 		return bres, err
 	}
 
-	if err = k.modifier.ModifyReq(req); err != nil {
+	// protocol errors of modifiers deny the request, the client gets an
+	// error response and nothing is forwarded
+	rec, err := k.modifier.modifyReq(req, c)
+	defer func() {
+		if k.audit == nil {
+			return
+		}
+		if rec.Outcome == "" && err != nil {
+			rec.fail(err)
+		}
+		if aerr := k.audit.Write(rec); aerr != nil && bres != nil {
+			bres, err = ErrorReply(req, NewProtocolError(ErrCodeActionCancelledOrDenied, "Audit log unavailable"))
+		}
+	}()
+	if err != nil {
+		switch e := err.(type) {
+		case *ProtocolError:
+			rec.fail(err)
+			return ErrorReply(req, err)
+		case *MitmReply:
+			defer e.Data.Wipe()
+			rec.result(&ConnMsg{}, e.Data)
+			return k.modifier.reply(req, c, e.Data)
+		}
 		return bres, err
 	}
 
@@ -135,67 +476,78 @@ func (k *KpXcMitm) HandleReq(breq []byte) (bres []byte, err error) {
 		return bres, err
 	}
 
-	if err = k.conn.Send(jreq); err != nil {
-		return bres, err
+	s, err := k.modifier.session(c, req.ClientId)
+	if err != nil {
+		rec.fail(err)
+		return ErrorReply(req, err)
 	}
-
-	jres, err := k.conn.Recv(BufSize, 0)
+	if k.audit != nil {
+		if err = k.audit.writePending(rec); err != nil {
+			err = NewProtocolError(ErrCodeActionCancelledOrDenied, "Audit log unavailable")
+			rec.fail(err)
+			return ErrorReply(req, err)
+		}
+	}
+	p := s.upstream()
+	jres, err := p.Exchange(jreq, UpstreamTimeout)
 	if err != nil {
-		return bres, err
+		if _, ok := err.(*ProtocolError); ok {
+			// no answer in time, KeePassXC may still be waiting for the user
+			rec.fail(err)
+			return ErrorReply(req, err)
+		}
+		// KeePassXC went away, the next request of the client reconnects
+		p.Close()
+		rec.fail(errNotRunning())
+		return ErrorReply(req, errNotRunning())
 	}
 
 	res, err := ParseConnMsg(jres)
 	if err != nil {
 		return bres, err
 	}
+	res.req = req
 
-	if err = k.modifier.ModifyRes(res); err != nil {
+	if err = k.modifier.modifyRes(res, c); err != nil {
+		if _, ok := err.(*ProtocolError); ok {
+			rec.fail(err)
+			return ErrorReply(req, err)
+		}
 		return bres, err
 	}
+	rec.result(res, res.data)
 
 	return json.Marshal(res)
 }
 
-func NewKpXcMitm(modifier KpXcMitmI) (ret *KpXcMitm, err error) {
+// NewKpXcMitm creates a MITM applying the modifiers as MitmChain. KeePassXC
+// is connected for every client on its key exchange, while it is not
+// running the client gets ErrCodeTimeoutOrNotConnected responses.
+func NewKpXcMitm(modifiers ...KpXcMitmI) (ret *KpXcMitm, err error) {
 	mod := new(kpXcModifier)
-	mod.modifier = modifier
-	mod.keyPair = sodium.MakeBoxKP()
-	serv := new(KpXcMitm)
-	serv.modifier = mod
-
-	var serverAddress string
-
-	tmpDir := os.Getenv("TMPDIR")
-	if tmpDir != "" {
-		tmpDir = path.Join(tmpDir, SocketName)
+	mod.sessions = make(map[mitmKey]*mitmSession)
+	mod.timeout = DefaultMitmSessionTimeout
+	mod.notify = make(chan []byte, NotifyBufSize)
+	if len(modifiers) == 1 {
+		mod.modifier = modifiers[0]
+	} else if len(modifiers) > 1 {
+		mod.modifier = MitmChain(modifiers)
 	}
+	mod.dialer = newUpstreamDialer("", nil)
+	ret = new(KpXcMitm)
+	ret.modifier = mod
+	ret.client = &KpXcMitmClient{mitm: ret, notify: mod.notify}
 
-	xdgRuntimeDir := os.Getenv(("XDG_RUNTIME_DIR"))
-	if xdgRuntimeDir != "" {
-		xdgRuntimeDir = path.Join(xdgRuntimeDir, SocketName)
-	}
-
-	oss := runtime.GOOS
-	switch oss {
-	case "linux":
-		serv.conn = &PosixConnection{}
-		if _, err = os.Stat(tmpDir); err == nil {
-			serverAddress = tmpDir
-			break
-		}
-		if _, err := os.Stat(xdgRuntimeDir); err == nil {
-			serverAddress = xdgRuntimeDir
-			break
-		}
-		return serv, fmt.Errorf("Unable to locate keepassxc socket")
-	default:
-		return serv, fmt.Errorf("Operating System: '%s' not supported", oss)
-	}
+	return ret, nil
+}
 
-	if err = serv.conn.Connect(serverAddress); err != nil {
-		return serv, err
+// Close ends all client sessions and their upstream connections.
+func (k *KpXcMitm) Close() {
+	m := k.modifier
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, s := range m.sessions {
+		s.close()
+		delete(m.sessions, key)
 	}
-	ret = serv
-
-	return ret, err
 }
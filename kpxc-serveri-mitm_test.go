@@ -0,0 +1,258 @@
+package keepassxc_browser
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// browserSocket serves BrowserServers on a Unix socket like KeePassXC and
+// hands out the accepted connections.
+func browserSocket(t *testing.T) (path string, conns chan net.Conn) {
+	t.Helper()
+	path = filepath.Join(t.TempDir(), "kpxc.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	conns = make(chan net.Conn, 4)
+	backend := &stubBackend{}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns <- c
+			go func() {
+				defer c.Close()
+				server, _ := NewBrowserServer(backend)
+				buf := make([]byte, BufSize)
+				for {
+					n, err := c.Read(buf)
+					if err != nil {
+						return
+					}
+					res, err := server.HandleReq(buf[:n])
+					if err != nil {
+						return
+					}
+					if _, err = c.Write(res); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	return path, conns
+}
+
+func TestKpXcMitmNotifications(t *testing.T) {
+	path, conns := browserSocket(t)
+	mitm, err := NewKpXcMitm()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mitm.modifier.dialer = newUpstreamDialer(path, nil)
+	defer mitm.Close()
+
+	var handlers []*KpXcMitmClient
+	var upstream []net.Conn
+	for _, id := range []string{"a", "b"} {
+		h := mitm.NewClient()
+		c, err := NewClient(id, WithConnection(&serverConnection{server: h}), WithAddress("mitm"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = c.ChangePublicKeys(); err != nil {
+			t.Fatal(err)
+		}
+		handlers = append(handlers, h)
+		upstream = append(upstream, <-conns)
+	}
+
+	note := `{"action":"database-locked"}`
+	if _, err = upstream[1].Write([]byte(note)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-handlers[1].Notifications():
+		if string(msg) != note {
+			t.Errorf("got %s, want %s", msg, note)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("notification not delivered")
+	}
+	select {
+	case msg := <-handlers[0].Notifications():
+		t.Errorf("notification of b delivered to a: %s", msg)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestKpXcMitmReconnectHang(t *testing.T) {
+	defer func(timeout time.Duration) { UpstreamHandshakeTimeout = timeout }(UpstreamHandshakeTimeout)
+	UpstreamHandshakeTimeout = 500 * time.Millisecond
+
+	// accepts, but never answers
+	path := testSocket(t, func(msg []byte) []byte { return nil })
+	mitm, err := NewKpXcMitm()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mitm.modifier.dialer = newUpstreamDialer(path, nil)
+
+	lost := newUpstreamPump(&scriptConn{recv: make(chan []byte)}, nil)
+	lost.Close()
+	<-lost.done
+	s := &mitmSession{pump: lost, lastUsed: time.Now()}
+	s.keyPair, _ = MakeBoxKP()
+	mitm.modifier.sessions[mitmKey{client: mitm.client, clientId: "a"}] = s
+
+	done := make(chan error, 1)
+	go func() { done <- s.reconnect(mitm.modifier.dialer, "a") }()
+	time.Sleep(100 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		mitm.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(250 * time.Millisecond):
+		t.Fatal("Close blocked by the reconnect")
+	}
+
+	select {
+	case err = <-done:
+		if err == nil {
+			t.Error("reconnect to a silent KeePassXC succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handshake not bounded")
+	}
+}
+
+func newMitm(t *testing.T) (mitm *KpXcMitm, conns chan net.Conn) {
+	t.Helper()
+	path, conns := browserSocket(t)
+	mitm, err := NewKpXcMitm()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mitm.modifier.dialer = newUpstreamDialer(path, nil)
+	t.Cleanup(mitm.Close)
+
+	return mitm, conns
+}
+
+func newMitmClient(t *testing.T, h ServerI, clientId string) *Client {
+	t.Helper()
+	c, err := NewClient(clientId, WithConnection(&serverConnection{server: h}), WithAddress("mitm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.ChangePublicKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestKpXcMitmConcurrentClients(t *testing.T) {
+	mitm, _ := newMitm(t)
+
+	// both connections use the same client id
+	h1, h2 := mitm.NewClient(), mitm.NewClient()
+	c1, c2 := newMitmClient(t, h1, "c"), newMitmClient(t, h2, "c")
+	if n := mitm.Sessions(); n != 2 {
+		t.Fatalf("%d sessions, want 2", n)
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range []*Client{c1, c2} {
+		wg.Add(1)
+		go func(c *Client) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				if _, err := c.GetDatabasehash(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	// a key exchange on another connection leaves the session alone
+	newMitmClient(t, h2, "c")
+	if _, err := c1.GetDatabasehash(); err != nil {
+		t.Errorf("session taken over by another connection: %v", err)
+	}
+
+	// and so does the end of another connection
+	h2.Close()
+	if n := mitm.Sessions(); n != 1 {
+		t.Errorf("%d sessions after closing a client, want 1", n)
+	}
+	if _, err := c1.GetDatabasehash(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestKpXcMitmInvalidKey(t *testing.T) {
+	mitm, conns := newMitm(t)
+	nonce, _ := RandomNonce()
+	req := fmt.Sprintf(`{"action":"change-public-keys","clientID":"c","publicKey":"c2hvcnQ=","nonce":"%s"}`,
+		base64.StdEncoding.EncodeToString(nonce.Bytes))
+
+	res, err := mitm.NewClient().HandleReq([]byte(req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(res), fmt.Sprintf(`"errorCode":"%d"`, ErrCodeKeyChangeFailed)) {
+		t.Errorf("got %s, want key change failed", res)
+	}
+	select {
+	case <-conns:
+		t.Error("KeePassXC dialed for an invalid key")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestKpXcMitmSessionExpiry(t *testing.T) {
+	mitm, conns := newMitm(t)
+	mitm.SetSessionTimeout(100 * time.Millisecond)
+
+	c := newMitmClient(t, mitm.NewClient(), "c")
+	upstream := <-conns
+	if n := mitm.Sessions(); n != 1 {
+		t.Fatalf("%d sessions, want 1", n)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := mitm.Sessions(); n != 0 {
+		t.Errorf("%d sessions after the timeout, want 0", n)
+	}
+
+	// the upstream connection is closed with it
+	upstream.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err := upstream.Read(make([]byte, 1))
+	if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+		t.Errorf("upstream connection still open: %v", err)
+	}
+
+	var perr *ProtocolError
+	if _, err = c.GetDatabasehash(); !errors.As(err, &perr) || perr.Code != ErrCodeClientPublicKeyNotReceived {
+		t.Errorf("got %v, want client public key not received", err)
+	}
+}
@@ -1,64 +1,115 @@
 package keepassxc_browser
 
 import (
-	"fmt"
-	"os"
-	"path"
-	"runtime"
+	"encoding/json"
+	"sync"
 )
 
+// KpXcProxy relays the messages of a client to KeePassXC as they are. It
+// connects on the first request and reconnects after KeePassXC restarted,
+// while KeePassXC is not running the client gets ErrCodeTimeoutOrNotConnected
+// responses. A restarted KeePassXC has forgotten the client keys, so clients
+// have to repeat change-public-keys after a reconnect.
 type KpXcProxy struct {
-	conn ConnectionI
+	mu     sync.Mutex
+	dialer *upstreamDialer
+	pump   *upstreamPump
+	notify chan []byte
+	audit  *AuditLog
 }
 
-func (k *KpXcProxy) HandleReq(breq []byte) (res []byte, err error) {
+// SetAuditLog records every request with its outcome to audit, nil stops
+// recording. The proxy cannot decrypt the messages, so the records lack
+// URL and entries. Requests are recorded as pending before they are
+// forwarded and denied if their records cannot be written.
+func (k *KpXcProxy) SetAuditLog(audit *AuditLog) {
+	k.audit = audit
+}
 
-	if err = k.conn.Send(breq); err != nil {
-		return res, err
-	}
+func (k *KpXcProxy) upstream() (ret *upstreamPump, err error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
 
-	if res, err = k.conn.Recv(BufSize, 0); err != nil {
-		return res, err
+	if k.pump != nil && !k.pump.closed() {
+		return k.pump, nil
 	}
+	conn, err := k.dialer.dial()
+	if err != nil {
+		return nil, err
+	}
+	k.pump = newUpstreamPump(conn, k.notify)
 
-	return res, err
+	return k.pump, nil
 }
 
-func NewKpXcProxy() (ret *KpXcProxy, err error) {
-	serv := new(KpXcProxy)
-	var serverAddress string
-
-	tmpDir := os.Getenv("TMPDIR")
-	if tmpDir != "" {
-		tmpDir = path.Join(tmpDir, SocketName)
+func (k *KpXcProxy) exchange(req *ConnMsg, breq []byte) (res []byte, err error) {
+	p, err := k.upstream()
+	if err == nil {
+		if res, err = p.Exchange(breq, UpstreamTimeout); err == nil {
+			return res, nil
+		}
+		if _, ok := err.(*ProtocolError); ok {
+			// no answer in time, KeePassXC may still be waiting for the user
+			return ErrorReply(req, err)
+		}
+		// KeePassXC went away, the next request reconnects
+		p.Close()
 	}
 
-	xdgRuntimeDir := os.Getenv(("XDG_RUNTIME_DIR"))
-	if xdgRuntimeDir != "" {
-		xdgRuntimeDir = path.Join(xdgRuntimeDir, SocketName)
+	return ErrorReply(req, errNotRunning())
+}
+
+func (k *KpXcProxy) HandleReq(breq []byte) (res []byte, err error) {
+	req := new(ConnMsg)
+	if err = json.Unmarshal(breq, req); err != nil {
+		return nil, err
+	}
+	if k.audit == nil {
+		return k.exchange(req, breq)
 	}
 
-	oss := runtime.GOOS
-	switch oss {
-	case "linux":
-		serv.conn = &PosixConnection{}
-		if _, err = os.Stat(tmpDir); err == nil {
-			serverAddress = tmpDir
-			break
-		}
-		if _, err := os.Stat(xdgRuntimeDir); err == nil {
-			serverAddress = xdgRuntimeDir
-			break
+	rec := newAuditRecord(req)
+	if err = k.audit.writePending(rec); err != nil {
+		return ErrorReply(req, NewProtocolError(ErrCodeActionCancelledOrDenied, "Audit log unavailable"))
+	}
+	if res, err = k.exchange(req, breq); err != nil {
+		rec.fail(err)
+	} else {
+		// forwarded anyway, the proxy does not judge the messages
+		cres := new(ConnMsg)
+		if jerr := json.Unmarshal(res, cres); jerr != nil {
+			rec.fail(jerr)
+		} else {
+			rec.result(cres, nil)
 		}
-		return serv, fmt.Errorf("Unable to locate keepassxc socket")
-	default:
-		return serv, fmt.Errorf("Operating System: '%s' not supported", oss)
 	}
+	if aerr := k.audit.Write(rec); aerr != nil && err == nil {
+		return ErrorReply(req, NewProtocolError(ErrCodeActionCancelledOrDenied, "Audit log unavailable"))
+	}
+
+	return res, err
+}
+
+// Notifications returns the messages KeePassXC sent without a request,
+// e.g. database-locked.
+func (k *KpXcProxy) Notifications() <-chan []byte {
+	return k.notify
+}
 
-	if err = serv.conn.Connect(serverAddress); err != nil {
-		return serv, err
+func (k *KpXcProxy) Close() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.pump != nil {
+		k.pump.Close()
 	}
-	ret = serv
+}
+
+// NewKpXcProxy creates a proxy for the KeePassXC socket, which need not
+// exist yet.
+func NewKpXcProxy() (ret *KpXcProxy, err error) {
+	ret = new(KpXcProxy)
+	ret.dialer = newUpstreamDialer("", nil)
+	ret.notify = make(chan []byte, NotifyBufSize)
 
-	return ret, err
+	return ret, nil
 }
@@ -0,0 +1,136 @@
+package keepassxc_browser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+)
+
+// ServerFactory creates the handler for a newly accepted client. Handlers
+// with a Close method are closed once their client is gone.
+type ServerFactory func() (ServerI, error)
+
+// SocketServer listens on its own Unix socket, speaking the same protocol as
+// the KeePassXC socket, and serves every client with a separate Server and
+// handler, so each one has its own session keys and state.
+type SocketServer struct {
+	path    string
+	factory ServerFactory
+	opts    []ServerOption
+	logger  LoggerI
+}
+
+func (s *SocketServer) listen() (ret *net.UnixListener, err error) {
+	if fi, err := os.Lstat(s.path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("Refusing to replace non socket: %s", s.path)
+		}
+		// stale socket of a previous run
+		if c, err := net.Dial("unix", s.path); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("Socket already in use: %s", s.path)
+		}
+		if err = os.Remove(s.path); err != nil {
+			return nil, err
+		}
+	}
+
+	if ret, err = net.ListenUnix("unix", &net.UnixAddr{Name: s.path, Net: "unix"}); err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(s.path, 0600); err != nil {
+		ret.Close()
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// Serve accepts clients until ctx is cancelled and waits for all of them to
+// finish before returning.
+func (s *SocketServer) Serve(ctx context.Context) (err error) {
+	l, err := s.listen()
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		c, err := l.AcceptUnix()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			s.logger.Printf("Accept failed: %v\n", err)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.serveConn(ctx, c); err != nil && ctx.Err() == nil {
+				s.logger.Printf("Client failed: %v\n", err)
+			}
+		}()
+	}
+}
+
+func (s *SocketServer) serveConn(ctx context.Context, c *net.UnixConn) (err error) {
+	conn := &PosixConnection{c: c}
+	defer conn.Close()
+
+	handler, err := s.factory()
+	if err != nil {
+		return err
+	}
+	// e.g. KpXcMitmClient ending the sessions of the client
+	if h, ok := handler.(interface{ Close() }); ok {
+		defer h.Close()
+	}
+
+	srv, err := NewServer(handler, conn, s.opts...)
+	if err != nil {
+		return err
+	}
+
+	return srv.Serve(ctx)
+}
+
+// NewSocketServer creates a server listening on path, factory is called for
+// every client. logger gets the errors of the listener, it and the options
+// are passed on to the Server of each client.
+func NewSocketServer(path string, factory ServerFactory, logger LoggerI, opts ...ServerOption) (ret *SocketServer, err error) {
+	if path == "" {
+		return nil, fmt.Errorf("Socket path required")
+	}
+	if factory == nil {
+		return nil, fmt.Errorf("Server factory required")
+	}
+	if logger == nil {
+		logger = nopLogger{}
+	}
+
+	ret = new(SocketServer)
+	ret.path = path
+	ret.factory = factory
+	ret.logger = logger
+	ret.opts = append([]ServerOption{WithServerLogger(logger)}, opts...)
+
+	return ret, nil
+}
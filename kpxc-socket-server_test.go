@@ -0,0 +1,132 @@
+package keepassxc_browser
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// closeServer counts the handlers closed by SocketServer.
+type closeServer struct {
+	*BrowserServer
+	closed *int32
+}
+
+func (s closeServer) Close() { atomic.AddInt32(s.closed, 1) }
+
+func startSocketServer(t *testing.T, factory ServerFactory) (path string, cancel func(), done chan error) {
+	t.Helper()
+	path = filepath.Join(t.TempDir(), "kpxc.sock")
+	srv, err := NewSocketServer(path, factory, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done = make(chan error, 1)
+	go func() { done <- srv.Serve(ctx) }()
+	t.Cleanup(cancel)
+	for i := 0; i < 100; i++ {
+		if _, err = os.Stat(path); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return path, cancel, done
+}
+
+func socketClient(t *testing.T, path, clientId string) *Client {
+	t.Helper()
+	c, err := NewClient(clientId, WithAddress(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.ChangePublicKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestSocketServerClients(t *testing.T) {
+	var closed int32
+	backend := &stubBackend{}
+	path, _, _ := startSocketServer(t, func() (ServerI, error) {
+		s, err := NewBrowserServer(backend)
+		return closeServer{s, &closed}, err
+	})
+
+	// every client has its own session
+	var clients []*Client
+	for _, id := range []string{"a", "b", "c"} {
+		clients = append(clients, socketClient(t, path, id))
+	}
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c *Client) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				if res, err := c.GetDatabasehash(); err != nil || res.Hash != "stubhash" {
+					t.Errorf("client %s: %v", c.ClientId, err)
+					return
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	clients[0].Close()
+	for i := 0; i < 100 && atomic.LoadInt32(&closed) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&closed); n != 1 {
+		t.Errorf("%d handlers closed, want 1", n)
+	}
+	if _, err := clients[1].GetDatabasehash(); err != nil {
+		t.Errorf("other client after a disconnect: %v", err)
+	}
+}
+
+func TestSocketServerShutdown(t *testing.T) {
+	path, cancel, done := startSocketServer(t, BrowserServerFactory(&stubBackend{}))
+	c := socketClient(t, path, "a")
+	defer c.Close()
+
+	// a second server must not take the socket over
+	srv, _ := NewSocketServer(path, BrowserServerFactory(&stubBackend{}), nil)
+	if err := srv.Serve(context.Background()); err == nil {
+		t.Error("second server took over the socket")
+	}
+
+	// returns with clients still connected
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("got %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return")
+	}
+	if _, err := c.GetDatabasehash(); err == nil {
+		t.Error("client still served after shutdown")
+	}
+
+	// a file that is no socket is left alone
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	srv, _ = NewSocketServer(file, BrowserServerFactory(&stubBackend{}), nil)
+	if err := srv.Serve(context.Background()); err == nil {
+		t.Error("file replaced by a socket")
+	}
+}
@@ -0,0 +1,79 @@
+package keepassxc_browser
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+)
+
+// SocketEnv overrides socket discovery with an explicit path.
+const SocketEnv string = "KEEPASSXC_BROWSER_SOCKET"
+
+const LegacySocketName string = "kpxc_server"
+const FlatpakAppId string = "org.keepassxc.KeePassXC"
+const SnapAppName string = "keepassxc"
+
+// SocketCandidates returns every location KeePassXC is known to put its
+// browser socket into on this system, most likely first.
+func SocketCandidates() (ret []string) {
+	add := func(elems ...string) {
+		for _, e := range elems {
+			if e == "" {
+				return
+			}
+		}
+		ret = append(ret, path.Join(elems...))
+	}
+
+	xdgRuntimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	tmpDir := os.Getenv("TMPDIR")
+	if xdgRuntimeDir == "" {
+		xdgRuntimeDir = fmt.Sprintf("/run/user/%d", os.Getuid())
+	}
+
+	// newer releases and the flatpak use the app subdirectory
+	add(xdgRuntimeDir, "app", FlatpakAppId, SocketName)
+	add(xdgRuntimeDir, FlatpakAppId, SocketName)
+	add(xdgRuntimeDir, SocketName)
+	add(tmpDir, SocketName)
+	add(xdgRuntimeDir, "snap."+SnapAppName, SocketName)
+	if home, err := os.UserHomeDir(); err == nil {
+		add(home, "snap", SnapAppName, "common", SocketName)
+	}
+	add(xdgRuntimeDir, LegacySocketName)
+	add(tmpDir, LegacySocketName)
+	add("/tmp", SocketName)
+
+	return ret
+}
+
+// ResolveSocket returns the path of the KeePassXC browser socket. An explicit
+// address or the SocketEnv environment variable takes precedence over the
+// well-known locations.
+func ResolveSocket(address string) (ret string, err error) {
+	if address != "" {
+		return address, nil
+	}
+	if env := os.Getenv(SocketEnv); env != "" {
+		return env, nil
+	}
+
+	oss := runtime.GOOS
+	switch oss {
+	case "linux":
+	default:
+		return "", fmt.Errorf("Operating System: '%s' not supported", oss)
+	}
+
+	tried := SocketCandidates()
+	for _, p := range tried {
+		if fi, err := os.Stat(p); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			return p, nil
+		}
+	}
+
+	return "", fmt.Errorf("Unable to locate keepassxc socket, tried: %s (set %s to override)",
+		strings.Join(tried, ", "), SocketEnv)
+}
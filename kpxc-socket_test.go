@@ -0,0 +1,157 @@
+package keepassxc_browser
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSocketCandidates(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	snap := filepath.Join(home, "snap", SnapAppName, "common", SocketName)
+	uid := fmt.Sprintf("/run/user/%d", os.Getuid())
+
+	for _, tc := range []struct {
+		name string
+		xdg  string
+		tmp  string
+		want []string
+	}{
+		{"xdg", "/xdg", "", []string{
+			"/xdg/app/" + FlatpakAppId + "/" + SocketName,
+			"/xdg/" + FlatpakAppId + "/" + SocketName,
+			"/xdg/" + SocketName,
+			"/xdg/snap." + SnapAppName + "/" + SocketName,
+			snap,
+			"/xdg/" + LegacySocketName,
+			"/tmp/" + SocketName,
+		}},
+		{"tmpdir", "/xdg", "/var/tmp", []string{
+			"/xdg/app/" + FlatpakAppId + "/" + SocketName,
+			"/xdg/" + FlatpakAppId + "/" + SocketName,
+			"/xdg/" + SocketName,
+			"/var/tmp/" + SocketName,
+			"/xdg/snap." + SnapAppName + "/" + SocketName,
+			snap,
+			"/xdg/" + LegacySocketName,
+			"/var/tmp/" + LegacySocketName,
+			"/tmp/" + SocketName,
+		}},
+		{"no xdg", "", "", []string{
+			uid + "/app/" + FlatpakAppId + "/" + SocketName,
+			uid + "/" + FlatpakAppId + "/" + SocketName,
+			uid + "/" + SocketName,
+			uid + "/snap." + SnapAppName + "/" + SocketName,
+			snap,
+			uid + "/" + LegacySocketName,
+			"/tmp/" + SocketName,
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("XDG_RUNTIME_DIR", tc.xdg)
+			t.Setenv("TMPDIR", tc.tmp)
+			if got := SocketCandidates(); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v\nwant %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// listenSocket creates a unix socket at path.
+func listenSocket(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+}
+
+func TestResolveSocket(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("socket discovery is linux only")
+	}
+
+	for _, tc := range []struct {
+		name    string
+		address string
+		env     string
+		// setup creates files below the runtime directory and returns
+		// the expected path
+		setup func(t *testing.T, dir string) string
+		err   bool
+	}{
+		{name: "address", address: "/explicit.sock", env: "/env.sock", setup: func(t *testing.T, dir string) string {
+			return "/explicit.sock"
+		}},
+		{name: "env", env: "/env.sock", setup: func(t *testing.T, dir string) string {
+			return "/env.sock"
+		}},
+		{name: "flatpak", setup: func(t *testing.T, dir string) string {
+			p := filepath.Join(dir, "app", FlatpakAppId, SocketName)
+			listenSocket(t, p)
+			return p
+		}},
+		{name: "legacy", setup: func(t *testing.T, dir string) string {
+			p := filepath.Join(dir, LegacySocketName)
+			listenSocket(t, p)
+			return p
+		}},
+		{name: "no socket", setup: func(t *testing.T, dir string) string {
+			// a regular file is no socket
+			listenSocket(t, filepath.Join(dir, "other"))
+			if err := os.WriteFile(filepath.Join(dir, SocketName), nil, 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(filepath.Join("/tmp", SocketName)); err == nil {
+				t.Skip("KeePassXC socket in /tmp")
+			}
+			return ""
+		}, err: true},
+		{name: "skip file", setup: func(t *testing.T, dir string) string {
+			if err := os.WriteFile(filepath.Join(dir, SocketName), nil, 0600); err != nil {
+				t.Fatal(err)
+			}
+			p := filepath.Join(dir, LegacySocketName)
+			listenSocket(t, p)
+			return p
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// socket paths are limited to about 100 bytes
+			dir, err := os.MkdirTemp("", "kpxc")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { os.RemoveAll(dir) })
+			t.Setenv("XDG_RUNTIME_DIR", dir)
+			t.Setenv("TMPDIR", "")
+			t.Setenv("HOME", dir)
+			t.Setenv(SocketEnv, tc.env)
+			want := tc.setup(t, dir)
+
+			got, err := ResolveSocket(tc.address)
+			if tc.err {
+				if err == nil || !strings.Contains(err.Error(), SocketEnv) {
+					t.Errorf("got %q, %v, want an error naming %s", got, err, SocketEnv)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		})
+	}
+}
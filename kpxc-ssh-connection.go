@@ -0,0 +1,142 @@
+package keepassxc_browser
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type sshRecv struct {
+	data []byte
+	err  error
+}
+
+// SshConnection reaches the KeePassXC socket of a remote host through an
+// SSH streamlocal forwarding. Connect takes the path of the remote socket.
+// Either set Client to reuse an established SSH connection or Host and
+// Config to dial one.
+type SshConnection struct {
+	Host   string
+	Config *ssh.ClientConfig
+	Client *ssh.Client
+	client *ssh.Client
+	c      net.Conn
+	recv   chan sshRecv
+	done   chan struct{}
+}
+
+func (conn *SshConnection) Connect(address string) (err error) {
+	if address == "" {
+		return fmt.Errorf("Remote socket path required")
+	}
+
+	conn.client = conn.Client
+	if conn.client == nil {
+		if conn.Host == "" || conn.Config == nil {
+			return fmt.Errorf("Either Client or Host and Config required")
+		}
+		if conn.client, err = ssh.Dial("tcp", conn.Host, conn.Config); err != nil {
+			return err
+		}
+	}
+
+	if conn.c, err = conn.client.Dial("unix", address); err != nil {
+		conn.closeClient()
+		return err
+	}
+
+	// ssh channels have no read deadlines, so read in the background
+	conn.recv = make(chan sshRecv, 1)
+	conn.done = make(chan struct{})
+	go conn.reader(conn.c, conn.recv, conn.done)
+
+	return nil
+}
+
+// reader stops once done is closed, even if nobody receives anymore.
+func (conn *SshConnection) reader(c net.Conn, recv chan<- sshRecv, done <-chan struct{}) {
+	defer close(recv)
+	buf := make([]byte, BufSize)
+	for {
+		n, err := c.Read(buf)
+		select {
+		case recv <- sshRecv{data: append([]byte(nil), buf[:n]...), err: err}:
+		case <-done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (conn *SshConnection) closeClient() {
+	if conn.client != nil && conn.client != conn.Client {
+		conn.client.Close()
+	}
+	conn.client = nil
+}
+
+func (conn *SshConnection) Close() {
+	if conn.done != nil {
+		close(conn.done)
+		conn.done = nil
+	}
+	if conn.c != nil {
+		conn.c.Close()
+	}
+	conn.closeClient()
+}
+
+func (conn *SshConnection) Send(message []byte) (err error) {
+	if conn.c == nil {
+		return fmt.Errorf("No connection established")
+	}
+	// a reply arriving after a Recv timeout belongs to an earlier request
+	for stale := true; stale; {
+		select {
+		case r, ok := <-conn.recv:
+			if !ok {
+				return fmt.Errorf("Connection closed")
+			}
+			if r.err != nil {
+				return r.err
+			}
+		default:
+			stale = false
+		}
+	}
+	_, err = conn.c.Write(message)
+	return err
+}
+
+func (conn *SshConnection) Recv(bufsize int, timeout int) (ret []byte, err error) {
+	if conn.c == nil {
+		return nil, fmt.Errorf("No connection established")
+	}
+
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(time.Second * time.Duration(timeout))
+		defer t.Stop()
+		timer = t.C
+	}
+
+	select {
+	case r, ok := <-conn.recv:
+		if !ok {
+			return nil, fmt.Errorf("Connection closed")
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		if len(r.data) > bufsize {
+			return nil, fmt.Errorf("Message too large: %d > %d", len(r.data), bufsize)
+		}
+		return r.data, nil
+	case <-timer:
+		return nil, fmt.Errorf("Receive timeout")
+	}
+}
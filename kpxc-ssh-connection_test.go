@@ -0,0 +1,217 @@
+package keepassxc_browser
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshServer starts an in-process SSH server accepting the public key of the
+// returned client config and forwarding direct-streamlocal channels to the
+// local socket they name.
+func sshServer(t *testing.T) (addr string, config *ssh.ClientConfig) {
+	t.Helper()
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, userPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userKey, err := ssh.NewSignerFromKey(userPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), userKey.PublicKey().Marshal()) {
+				return nil, io.EOF
+			}
+			return nil, nil
+		},
+	}
+	serverConfig.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSsh(c, serverConfig)
+		}
+	}()
+
+	return l.Addr().String(), &ssh.ClientConfig{
+		User:            "test",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(userKey)},
+		HostKeyCallback: ssh.FixedHostKey(hostKey.PublicKey()),
+		Timeout:         5 * time.Second,
+	}
+}
+
+func serveSsh(c net.Conn, config *ssh.ServerConfig) {
+	sc, chans, reqs, err := ssh.NewServerConn(c, config)
+	if err != nil {
+		c.Close()
+		return
+	}
+	defer sc.Close()
+	go ssh.DiscardRequests(reqs)
+
+	for nc := range chans {
+		if nc.ChannelType() != "direct-streamlocal@openssh.com" {
+			nc.Reject(ssh.UnknownChannelType, "unsupported")
+			continue
+		}
+		var msg struct {
+			SocketPath string
+			Reserved0  string
+			Reserved1  uint32
+		}
+		if err = ssh.Unmarshal(nc.ExtraData(), &msg); err != nil {
+			nc.Reject(ssh.Prohibited, "invalid request")
+			continue
+		}
+		local, err := net.Dial("unix", msg.SocketPath)
+		if err != nil {
+			nc.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		ch, creqs, err := nc.Accept()
+		if err != nil {
+			local.Close()
+			continue
+		}
+		go ssh.DiscardRequests(creqs)
+		go func() {
+			io.Copy(ch, local)
+			ch.Close()
+		}()
+		go func() {
+			io.Copy(local, ch)
+			local.Close()
+		}()
+	}
+}
+
+func TestSshConnection(t *testing.T) {
+	addr, config := sshServer(t)
+	testEcho(t, &SshConnection{Host: addr, Config: config}, echoSocket(t))
+}
+
+func TestSshConnectionClient(t *testing.T) {
+	addr, config := sshServer(t)
+	client, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	testEcho(t, &SshConnection{Client: client}, echoSocket(t))
+	// a shared client stays open
+	if _, _, err = client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+		t.Errorf("shared client closed: %v", err)
+	}
+}
+
+func TestSshConnectionUnknownKey(t *testing.T) {
+	addr, config := sshServer(t)
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	other, _ := ssh.NewSignerFromKey(priv)
+	config.Auth = []ssh.AuthMethod{ssh.PublicKeys(other)}
+
+	conn := &SshConnection{Host: addr, Config: config}
+	if err := conn.Connect(echoSocket(t)); err == nil {
+		conn.Close()
+		t.Fatal("connected with an unknown key")
+	}
+}
+
+// slowSocket delays the answers to messages starting with "slow".
+func slowSocket(t *testing.T, delay time.Duration) string {
+	return testSocket(t, func(msg []byte) []byte {
+		if strings.HasPrefix(string(msg), "slow") {
+			time.Sleep(delay)
+		}
+		return msg
+	})
+}
+
+func TestSshConnectionStaleReply(t *testing.T) {
+	addr, config := sshServer(t)
+	conn := &SshConnection{Host: addr, Config: config}
+	if err := conn.Connect(slowSocket(t, 1500*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.Send([]byte("slow")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Recv(BufSize, 1); err == nil {
+		t.Fatal("expected a timeout")
+	}
+	// let the late answer arrive
+	time.Sleep(time.Second)
+
+	if err := conn.Send([]byte("next")); err != nil {
+		t.Fatal(err)
+	}
+	ret, err := conn.Recv(BufSize, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(ret) != "next" {
+		t.Errorf("got %q, want the answer to the current request", ret)
+	}
+}
+
+func TestSshConnectionCloseStopsReader(t *testing.T) {
+	addr, config := sshServer(t)
+	conn := &SshConnection{Host: addr, Config: config}
+	if err := conn.Connect(echoSocket(t)); err != nil {
+		t.Fatal(err)
+	}
+	recv := conn.recv
+
+	// fill the channel and keep the reader blocked on the next answer
+	for _, msg := range []string{"a", "b", "c"} {
+		if _, err := conn.c.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	conn.Close()
+	time.Sleep(200 * time.Millisecond)
+
+	// only the buffered answer is left, a running reader would hand over
+	// the next one
+	<-recv
+	select {
+	case r, ok := <-recv:
+		if ok {
+			t.Errorf("reader still running after Close, got %q", r.data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("reader still running after Close")
+	}
+}
@@ -4,24 +4,41 @@ import (
 	"bufio"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"os"
 	"time"
 )
 
+// NativeEndian is the byte order of the native messaging length prefix.
+var NativeEndian binary.ByteOrder = binary.NativeEndian
+
+// StdinoutConnection speaks the browser native messaging framing, every
+// message is prefixed with its length as native endian uint32. In and Out
+// default to stdin and stdout.
 type StdinoutConnection struct {
+	In  io.Reader
+	Out io.Writer
 	in  *bufio.Reader
 	out *bufio.Writer
 }
 
 func (conn *StdinoutConnection) Connect(address string) (err error) {
-	conn.in = bufio.NewReader(os.Stdin)
-	conn.out = bufio.NewWriter(os.Stdout)
+	if conn.In == nil {
+		conn.In = os.Stdin
+	}
+	if conn.Out == nil {
+		conn.Out = os.Stdout
+	}
+	conn.in = bufio.NewReader(conn.In)
+	conn.out = bufio.NewWriter(conn.Out)
 
 	return err
 }
 
 func (conn *StdinoutConnection) Close() {
-	conn.out.Flush()
+	if conn.out != nil {
+		conn.out.Flush()
+	}
 }
 
 func (conn *StdinoutConnection) Send(message []byte) (err error) {
@@ -29,12 +46,7 @@ func (conn *StdinoutConnection) Send(message []byte) (err error) {
 		return fmt.Errorf("No connection established")
 	}
 
-	//messagelen := make([]byte, 4)
-	//binary.LittleEndian.PutUint32(messagelen, uint32(len(message)))
-	//msg := append(messagelen, message...)
-	//_, err = conn.out.Write(msg)
-	_, err = conn.out.Write(message)
-	if err != nil {
+	if err = writeFrame(conn.out, NativeEndian, message); err != nil {
 		return err
 	}
 
@@ -46,26 +58,9 @@ func (conn *StdinoutConnection) Recv(bufsize int, timeout int) (ret []byte, err
 		return nil, fmt.Errorf("No connection established")
 	}
 
-	blen := make([]byte, 4)
-	if timeout > 0 {
-		os.Stdin.SetReadDeadline(time.Now().Add(time.Duration(time.Second * time.Duration(timeout))))
-	}
-	_, err = conn.in.Read(blen)
-	if err != nil {
-		return blen, err
-	}
-
-	rlen := binary.LittleEndian.Uint32(blen)
-	ret = make([]byte, rlen)
-	n, err := conn.in.Read(ret)
-	if err != nil {
-		return ret, err
-	}
-	//slog.LOG_DEBUGF("Recv n: %d ret: %v\n", n, ret)
-	//slog.LOG_DEBUGF("Recv n: %d ret: %s\n", n, ret)
-	if n != int(rlen) {
-		return ret, fmt.Errorf("Incomplete message: n=%d rlen=%d", n, rlen)
+	if f, ok := conn.In.(*os.File); ok && timeout > 0 {
+		f.SetReadDeadline(time.Now().Add(time.Second * time.Duration(timeout)))
 	}
 
-	return ret, err
+	return readFrame(conn.in, NativeEndian, bufsize)
 }
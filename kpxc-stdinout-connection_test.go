@@ -0,0 +1,68 @@
+package keepassxc_browser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func TestStdinoutConnection(t *testing.T) {
+	var in, out bytes.Buffer
+	msg := []byte(`{"action":"get-databasehash"}`)
+	if err := writeFrame(&in, NativeEndian, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	conn := &StdinoutConnection{In: &in, Out: &out}
+	if err := conn.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	ret, err := conn.Recv(BufSize, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ret, msg) {
+		t.Errorf("got %q, want %q", ret, msg)
+	}
+	if _, err = conn.Recv(BufSize, 0); err != io.EOF {
+		t.Errorf("got %v at the end of input, want EOF", err)
+	}
+
+	if err = conn.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	frame := out.Bytes()
+	if len(frame) != 4+len(msg) || binary.NativeEndian.Uint32(frame) != uint32(len(msg)) {
+		t.Fatalf("got frame %x", frame)
+	}
+	if !bytes.Equal(frame[4:], msg) {
+		t.Errorf("got %q, want %q", frame[4:], msg)
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame []byte
+		max   int
+		want  string
+		fail  bool
+	}{
+		{"empty", []byte{0, 0, 0, 0}, 0, "", false},
+		{"message", []byte{2, 0, 0, 0, '{', '}'}, 0, "{}", false},
+		{"truncated length", []byte{2, 0}, 0, "", true},
+		{"truncated message", []byte{4, 0, 0, 0, '{', '}'}, 0, "", true},
+		{"too large", []byte{4, 0, 0, 0, '{', '"', '"', '}'}, 3, "", true},
+	}
+	for _, tt := range tests {
+		ret, err := readFrame(bytes.NewReader(tt.frame), binary.LittleEndian, tt.max)
+		if (err != nil) != tt.fail {
+			t.Errorf("%s: got error %v", tt.name, err)
+			continue
+		}
+		if err == nil && string(ret) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, ret, tt.want)
+		}
+	}
+}
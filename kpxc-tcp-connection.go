@@ -0,0 +1,63 @@
+package keepassxc_browser
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+const DefaultDialTimeout time.Duration = 10 * time.Second
+
+// TcpConnection talks to a KeePassXC socket exposed with TcpRelay. Messages
+// are framed with a 4 byte big endian length. Set TLSConfig to use TLS, a
+// config with Certificates enables mutual authentication.
+type TcpConnection struct {
+	TLSConfig   *tls.Config
+	DialTimeout time.Duration
+	c           net.Conn
+}
+
+func (conn *TcpConnection) Connect(address string) (err error) {
+	dialer := &net.Dialer{Timeout: conn.DialTimeout}
+	if dialer.Timeout == 0 {
+		dialer.Timeout = DefaultDialTimeout
+	}
+
+	if conn.TLSConfig != nil {
+		conn.c, err = tls.DialWithDialer(dialer, "tcp", address, conn.TLSConfig)
+	} else {
+		conn.c, err = dialer.Dial("tcp", address)
+	}
+
+	return err
+}
+
+func (conn *TcpConnection) Close() {
+	if conn.c != nil {
+		conn.c.Close()
+	}
+}
+
+func (conn *TcpConnection) Send(message []byte) (err error) {
+	if conn.c == nil {
+		return fmt.Errorf("No connection established")
+	}
+
+	return writeFrame(conn.c, binary.BigEndian, message)
+}
+
+func (conn *TcpConnection) Recv(bufsize int, timeout int) (ret []byte, err error) {
+	if conn.c == nil {
+		return nil, fmt.Errorf("No connection established")
+	}
+
+	if timeout > 0 {
+		conn.c.SetReadDeadline(time.Now().Add(time.Second * time.Duration(timeout)))
+	} else {
+		conn.c.SetReadDeadline(time.Time{})
+	}
+
+	return readFrame(conn.c, binary.BigEndian, bufsize)
+}
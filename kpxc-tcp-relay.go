@@ -0,0 +1,213 @@
+package keepassxc_browser
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// TcpRelay exposes the local KeePassXC socket on a TCP port so a Client
+// using TcpConnection can reach it from another host. Only peers matching
+// the allow-list are accepted.
+type TcpRelay struct {
+	socketPath string
+	tlsConfig  *tls.Config
+	allow      []*net.IPNet
+	logger     LoggerI
+	mu         sync.Mutex
+	listener   net.Listener
+	closed     bool
+	conns      map[net.Conn]bool
+	wg         sync.WaitGroup
+}
+
+func parseAllowList(allow []string) (ret []*net.IPNet, err error) {
+	for _, a := range allow {
+		if !strings.Contains(a, "/") {
+			ip := net.ParseIP(a)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid address in allow-list: %s", a)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			ret = append(ret, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(a)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid network in allow-list: %s", a)
+		}
+		ret = append(ret, ipnet)
+	}
+
+	return ret, nil
+}
+
+func (r *TcpRelay) allowed(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range r.allow {
+		if n.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *TcpRelay) ListenAndServe(address string) (err error) {
+	var l net.Listener
+	if r.tlsConfig != nil {
+		l, err = tls.Listen("tcp", address, r.tlsConfig)
+	} else {
+		l, err = net.Listen("tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+
+	return r.Serve(l)
+}
+
+// Serve accepts connections on l until Close is called.
+func (r *TcpRelay) Serve(l net.Listener) (err error) {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		l.Close()
+		return nil
+	}
+	r.listener = l
+	r.mu.Unlock()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			r.mu.Lock()
+			closed := r.closed
+			r.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		if !r.allowed(c.RemoteAddr()) {
+			r.logger.Printf("Rejected connection from %s\n", c.RemoteAddr())
+			c.Close()
+			continue
+		}
+
+		// Close may have run since Accept returned, it waits for the
+		// relays registered before only
+		r.mu.Lock()
+		if r.closed {
+			r.mu.Unlock()
+			c.Close()
+			return nil
+		}
+		r.conns[c] = true
+		r.wg.Add(1)
+		r.mu.Unlock()
+		go r.relay(c)
+	}
+}
+
+func (r *TcpRelay) relay(c net.Conn) {
+	defer r.wg.Done()
+	defer func() {
+		r.mu.Lock()
+		delete(r.conns, c)
+		r.mu.Unlock()
+		c.Close()
+	}()
+
+	upstream := &PosixConnection{}
+	if err := upstream.Connect(r.socketPath); err != nil {
+		r.logger.Printf("Unable to connect to keepassxc: %v\n", err)
+		return
+	}
+	defer upstream.Close()
+	r.logger.Printf("Relaying %s\n", c.RemoteAddr())
+
+	done := make(chan struct{}, 2)
+	go func() {
+		for {
+			msg, err := readFrame(c, binary.BigEndian, BufSize)
+			if err != nil {
+				break
+			}
+			if err = upstream.Send(msg); err != nil {
+				break
+			}
+		}
+		done <- struct{}{}
+	}()
+	go func() {
+		for {
+			msg, err := upstream.Recv(BufSize, 0)
+			if err != nil {
+				break
+			}
+			if err = writeFrame(c, binary.BigEndian, msg); err != nil {
+				break
+			}
+		}
+		done <- struct{}{}
+	}()
+
+	<-done
+	c.Close()
+	upstream.Close()
+	<-done
+}
+
+// Close stops accepting and terminates all relayed connections. The relay
+// cannot be served again afterwards.
+func (r *TcpRelay) Close() {
+	r.mu.Lock()
+	l := r.listener
+	r.listener = nil
+	r.closed = true
+	for c := range r.conns {
+		c.Close()
+	}
+	r.mu.Unlock()
+
+	if l != nil {
+		l.Close()
+	}
+	r.wg.Wait()
+}
+
+// NewTcpRelay creates a relay for the KeePassXC socket at socketPath (empty
+// to auto-discover). allow holds IP addresses or CIDR networks, tlsConfig
+// may be nil for plain TCP.
+func NewTcpRelay(socketPath string, allow []string, tlsConfig *tls.Config, logger LoggerI) (ret *TcpRelay, err error) {
+	ret = new(TcpRelay)
+	if ret.socketPath, err = ResolveSocket(socketPath); err != nil {
+		return nil, err
+	}
+	if ret.allow, err = parseAllowList(allow); err != nil {
+		return nil, err
+	}
+	if len(ret.allow) == 0 {
+		return nil, fmt.Errorf("Empty allow-list")
+	}
+	ret.tlsConfig = tlsConfig
+	ret.logger = logger
+	if ret.logger == nil {
+		ret.logger = nopLogger{}
+	}
+	ret.conns = make(map[net.Conn]bool)
+
+	return ret, nil
+}
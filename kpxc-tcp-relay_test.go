@@ -0,0 +1,206 @@
+package keepassxc_browser
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// echoSocket stands in for KeePassXC, it sends every message back.
+func echoSocket(t *testing.T) string {
+	t.Helper()
+	return testSocket(t, func(msg []byte) []byte { return msg })
+}
+
+// testSocket serves a Unix socket answering every message with reply.
+func testSocket(t *testing.T, reply func(msg []byte) []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "kpxc.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				buf := make([]byte, BufSize)
+				for {
+					n, err := c.Read(buf)
+					if err != nil {
+						return
+					}
+					if _, err = c.Write(reply(buf[:n])); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	return path
+}
+
+func startRelay(t *testing.T, allow []string, tlsConfig *tls.Config) (relay *TcpRelay, addr string, done chan error) {
+	t.Helper()
+	relay, err := NewTcpRelay(echoSocket(t), allow, tlsConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l net.Listener
+	if tlsConfig != nil {
+		l, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	} else {
+		l, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	done = make(chan error, 1)
+	go func() { done <- relay.Serve(l) }()
+	t.Cleanup(relay.Close)
+
+	return relay, l.Addr().String(), done
+}
+
+func testEcho(t *testing.T, conn ConnectionI, addr string) {
+	t.Helper()
+	if err := conn.Connect(addr); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, msg := range []string{`{"action":"change-public-keys"}`, `{"action":"get-databasehash"}`} {
+		if err := conn.Send([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		ret, err := conn.Recv(BufSize, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(ret) != msg {
+			t.Errorf("got %q, want %q", ret, msg)
+		}
+	}
+}
+
+func TestTcpRelayLoopback(t *testing.T) {
+	relay, addr, done := startRelay(t, []string{"127.0.0.1"}, nil)
+	testEcho(t, &TcpConnection{}, addr)
+
+	relay.Close()
+	if err := <-done; err != nil {
+		t.Errorf("Serve returned %v after Close", err)
+	}
+}
+
+func TestTcpRelayAllowList(t *testing.T) {
+	_, addr, _ := startRelay(t, []string{"10.0.0.0/8"}, nil)
+
+	conn := &TcpConnection{}
+	if err := conn.Connect(addr); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Send([]byte(`{}`))
+	if ret, err := conn.Recv(BufSize, 5); err == nil {
+		t.Errorf("rejected peer got %q", ret)
+	}
+}
+
+func selfSigned(t *testing.T, name string) (tls.Certificate, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, cert
+}
+
+func TestTcpRelayMutualTls(t *testing.T) {
+	serverCert, serverCa := selfSigned(t, "relay")
+	clientCert, clientCa := selfSigned(t, "client")
+	serverPool, clientPool := x509.NewCertPool(), x509.NewCertPool()
+	serverPool.AddCert(serverCa)
+	clientPool.AddCert(clientCa)
+
+	_, addr, _ := startRelay(t, []string{"127.0.0.0/8"}, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    clientPool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+
+	testEcho(t, &TcpConnection{TLSConfig: &tls.Config{
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      serverPool,
+	}}, addr)
+
+	// without a client certificate the handshake fails
+	conn := &TcpConnection{TLSConfig: &tls.Config{RootCAs: serverPool}}
+	if err := conn.Connect(addr); err == nil {
+		defer conn.Close()
+		conn.Send([]byte(`{}`))
+		if ret, err := conn.Recv(BufSize, 5); err == nil {
+			t.Errorf("peer without certificate got %q", ret)
+		}
+	}
+}
+
+// TestTcpRelayCloseWhileAccepting runs Close against connections coming in,
+// run it with -race.
+func TestTcpRelayCloseWhileAccepting(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		relay, addr, done := startRelay(t, []string{"127.0.0.1"}, nil)
+		var wg sync.WaitGroup
+		for j := 0; j < 8; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c, err := net.Dial("tcp", addr)
+				if err == nil {
+					c.Close()
+				}
+			}()
+		}
+		relay.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Serve returned %v after Close", err)
+		}
+		wg.Wait()
+	}
+}
@@ -0,0 +1,100 @@
+package keepassxc_browser
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type TotpSettings struct {
+	Secret    []byte
+	Digits    int
+	Period    int
+	Algorithm string
+}
+
+// ParseTotp parses an otpauth://totp/ URI as stored by KeePassXC in the
+// otp attribute, or a bare base32 secret with the default settings.
+func ParseTotp(otp string) (ret *TotpSettings, err error) {
+	ret = &TotpSettings{Digits: 6, Period: 30, Algorithm: "SHA1"}
+
+	secret := otp
+	if strings.HasPrefix(otp, "otpauth://") {
+		u, err := url.Parse(otp)
+		if err != nil {
+			return nil, err
+		}
+		if u.Host != "totp" {
+			return nil, fmt.Errorf("Unsupported otp type: %s", u.Host)
+		}
+		q := u.Query()
+		secret = q.Get("secret")
+		if v := q.Get("digits"); v != "" {
+			if ret.Digits, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("Invalid otp digits: %s", v)
+			}
+		}
+		if v := q.Get("period"); v != "" {
+			if ret.Period, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("Invalid otp period: %s", v)
+			}
+		}
+		if v := q.Get("algorithm"); v != "" {
+			ret.Algorithm = strings.ToUpper(v)
+		}
+	}
+
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	if ret.Secret, err = base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "=")); err != nil {
+		return nil, fmt.Errorf("Invalid otp secret")
+	}
+	if ret.Digits < 1 || ret.Digits > 10 || ret.Period < 1 {
+		return nil, fmt.Errorf("Invalid otp settings")
+	}
+
+	return ret, nil
+}
+
+// Generate returns the TOTP code (RFC 6238) valid at t.
+func (s *TotpSettings) Generate(t time.Time) (ret Secret, err error) {
+	var h func() hash.Hash
+	switch s.Algorithm {
+	case "SHA1":
+		h = sha1.New
+	case "SHA256":
+		h = sha256.New
+	case "SHA512":
+		h = sha512.New
+	default:
+		return nil, fmt.Errorf("Unsupported otp algorithm: %s", s.Algorithm)
+	}
+
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(t.Unix()/int64(s.Period)))
+	mac := hmac.New(h, s.Secret)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+	defer wipeBytes(sum)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := uint64(binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff)
+	mod := uint64(1)
+	for i := 0; i < s.Digits; i++ {
+		mod *= 10
+	}
+
+	return Secret(fmt.Sprintf("%0*d", s.Digits, code%mod)), nil
+}
+
+func (s *TotpSettings) Wipe() {
+	wipeBytes(s.Secret)
+}
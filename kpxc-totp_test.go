@@ -0,0 +1,69 @@
+package keepassxc_browser
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// TestTotpGenerate uses the test vectors of RFC 6238 appendix B.
+func TestTotpGenerate(t *testing.T) {
+	seeds := map[string]string{
+		"SHA1":   "12345678901234567890",
+		"SHA256": "12345678901234567890123456789012",
+		"SHA512": "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+	tests := []struct {
+		time int64
+		want map[string]string
+	}{
+		{59, map[string]string{"SHA1": "94287082", "SHA256": "46119246", "SHA512": "90693936"}},
+		{1111111109, map[string]string{"SHA1": "07081804", "SHA256": "68084774", "SHA512": "25091201"}},
+		{1111111111, map[string]string{"SHA1": "14050471", "SHA256": "67062674", "SHA512": "99943326"}},
+		{1234567890, map[string]string{"SHA1": "89005924", "SHA256": "91819424", "SHA512": "93441116"}},
+		{2000000000, map[string]string{"SHA1": "69279037", "SHA256": "90698825", "SHA512": "38618901"}},
+		{20000000000, map[string]string{"SHA1": "65353130", "SHA256": "77737706", "SHA512": "47863826"}},
+	}
+	for _, tt := range tests {
+		for alg, want := range tt.want {
+			s := &TotpSettings{Secret: []byte(seeds[alg]), Digits: 8, Period: 30, Algorithm: alg}
+			got, err := s.Generate(time.Unix(tt.time, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != want {
+				t.Errorf("%s at %d: got %s, want %s", alg, tt.time, got, want)
+			}
+		}
+	}
+}
+
+func TestParseTotp(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890123456789012"))
+	s, err := ParseTotp("otpauth://totp/Example:bob?secret=" + secret + "&digits=8&algorithm=sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Generate(time.Unix(59, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "46119246" {
+		t.Errorf("got %s, want 46119246", got)
+	}
+
+	// a bare secret gets the defaults
+	if s, err = ParseTotp(secret); err != nil || s.Digits != 6 || s.Period != 30 || s.Algorithm != "SHA1" {
+		t.Errorf("got %+v, %v", s, err)
+	}
+	for _, otp := range []string{
+		"otpauth://hotp/x?secret=" + secret,
+		"otpauth://totp/x?secret=" + secret + "&digits=0",
+		"otpauth://totp/x?secret=" + secret + "&period=x",
+		"not base32!",
+	} {
+		if _, err = ParseTotp(otp); err == nil {
+			t.Errorf("%s accepted", otp)
+		}
+	}
+}
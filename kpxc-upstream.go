@@ -0,0 +1,75 @@
+package keepassxc_browser
+
+import (
+	"sync"
+	"time"
+)
+
+// UpstreamRetryMin and UpstreamRetryMax bound the wait between attempts to
+// connect KeePassXC, it doubles with every failed attempt.
+var UpstreamRetryMin = 250 * time.Millisecond
+var UpstreamRetryMax = 30 * time.Second
+
+// UpstreamTimeout bounds the wait for an answer of KeePassXC, it leaves the
+// user time to confirm an access or association.
+var UpstreamTimeout = 2 * time.Minute
+
+// UpstreamHandshakeTimeout bounds the key exchange on a new connection to
+// KeePassXC, which needs no user interaction.
+var UpstreamHandshakeTimeout = 5 * time.Second
+
+func errNotRunning() error {
+	return NewProtocolError(ErrCodeTimeoutOrNotConnected, "KeePassXC not running")
+}
+
+func errTimeout() error {
+	return NewProtocolError(ErrCodeTimeoutOrNotConnected, "Timeout waiting for KeePassXC")
+}
+
+// upstreamDialer connects KeePassXC on demand. The socket is looked up on
+// every attempt, as it only appears once KeePassXC is started. Within the
+// backoff after a failed attempt errors are returned without dialing.
+type upstreamDialer struct {
+	mu      sync.Mutex
+	address string
+	next    time.Time
+	backoff time.Duration
+	logger  LoggerI
+}
+
+func (d *upstreamDialer) dial() (ret ConnectionI, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(d.next) {
+		return nil, errNotRunning()
+	}
+
+	address, err := ResolveSocket(d.address)
+	if err == nil {
+		conn := &PosixConnection{}
+		if err = conn.Connect(address); err == nil {
+			d.backoff = 0
+			d.next = time.Time{}
+			return conn, nil
+		}
+	}
+
+	if d.backoff == 0 {
+		d.backoff = UpstreamRetryMin
+	} else if d.backoff *= 2; d.backoff > UpstreamRetryMax {
+		d.backoff = UpstreamRetryMax
+	}
+	d.next = now.Add(d.backoff)
+	d.logger.Printf("Unable to connect KeePassXC, retrying in %v: %v\n", d.backoff, err)
+
+	return nil, errNotRunning()
+}
+
+func newUpstreamDialer(address string, logger LoggerI) *upstreamDialer {
+	if logger == nil {
+		logger = nopLogger{}
+	}
+	return &upstreamDialer{address: address, logger: logger}
+}
@@ -0,0 +1,82 @@
+package keepassxc_browser
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Match levels returned by MatchUrl, higher is better.
+const (
+	UrlMatchNone      int = 0
+	UrlMatchSubdomain int = 1
+	UrlMatchHost      int = 2
+	UrlMatchPath      int = 3
+	UrlMatchExact     int = 4
+)
+
+func parseMatchUrl(raw string) (ret *url.URL) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	ret, err := url.Parse(raw)
+	if err != nil || ret.Hostname() == "" {
+		return nil
+	}
+
+	return ret
+}
+
+// MatchUrl compares the URL of an entry with a requested URL similar to
+// KeePassXC: the hosts must match or the requested host must be a subdomain
+// of the entry host, scheme and port must match if the entry has them.
+func MatchUrl(entryUrl, reqUrl string) int {
+	e := parseMatchUrl(entryUrl)
+	r := parseMatchUrl(reqUrl)
+	if e == nil || r == nil {
+		return UrlMatchNone
+	}
+
+	if strings.Contains(entryUrl, "://") && !strings.EqualFold(e.Scheme, r.Scheme) {
+		return UrlMatchNone
+	}
+	if e.Port() != "" && e.Port() != r.Port() {
+		return UrlMatchNone
+	}
+
+	eh := strings.ToLower(e.Hostname())
+	rh := strings.ToLower(r.Hostname())
+	ret := UrlMatchHost
+	if eh != rh {
+		if !strings.HasSuffix(rh, "."+eh) {
+			return UrlMatchNone
+		}
+		ret = UrlMatchSubdomain
+	}
+
+	ep := strings.TrimSuffix(e.EscapedPath(), "/")
+	rp := strings.TrimSuffix(r.EscapedPath(), "/")
+	// the entry path has to end at a segment boundary, /app is no prefix
+	// of /apple
+	if ret == UrlMatchHost && ep != "" && (rp == ep || strings.HasPrefix(rp, ep+"/")) {
+		ret = UrlMatchPath
+		if ep == rp && e.RawQuery == r.RawQuery {
+			ret = UrlMatchExact
+		}
+	}
+
+	return ret
+}
+
+// UrlHost returns the lower case host name of a URL, which may lack a scheme.
+func UrlHost(raw string) string {
+	u := parseMatchUrl(raw)
+	if u == nil {
+		return ""
+	}
+
+	return strings.ToLower(u.Hostname())
+}
@@ -0,0 +1,34 @@
+package keepassxc_browser
+
+import (
+	"testing"
+)
+
+func TestMatchUrl(t *testing.T) {
+	tests := []struct {
+		entry, req string
+		want       int
+	}{
+		{"https://example.com", "https://example.com/login", UrlMatchHost},
+		{"example.com", "http://example.com", UrlMatchHost},
+		{"https://EXAMPLE.com", "https://example.com", UrlMatchHost},
+		{"https://example.com", "https://www.example.com", UrlMatchSubdomain},
+		{"https://example.com", "https://badexample.com", UrlMatchNone},
+		{"https://www.example.com", "https://example.com", UrlMatchNone},
+		{"https://example.com", "http://example.com", UrlMatchNone},
+		{"https://example.com:8443", "https://example.com", UrlMatchNone},
+		{"https://example.com:8443", "https://example.com:8443", UrlMatchHost},
+		{"https://example.com/app", "https://example.com/app/login", UrlMatchPath},
+		{"https://example.com/app/", "https://example.com/app", UrlMatchExact},
+		{"https://example.com/app", "https://example.com/apple", UrlMatchHost},
+		{"https://example.com/app?x=1", "https://example.com/app?x=1", UrlMatchExact},
+		{"https://example.com/app?x=1", "https://example.com/app?x=2", UrlMatchPath},
+		{"", "https://example.com", UrlMatchNone},
+		{"https://example.com", "not a url", UrlMatchNone},
+	}
+	for _, tt := range tests {
+		if got := MatchUrl(tt.entry, tt.req); got != tt.want {
+			t.Errorf("MatchUrl(%q, %q) = %d, want %d", tt.entry, tt.req, got, tt.want)
+		}
+	}
+}